@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Every lease is recorded in the job_attempts table under its lease_token, so the history
+// of a job shows which worker ran each attempt, how long it took and why it failed.
+// Outcomes: running, completed, failed, timed_out, cancelled or requeued
+
+func startAttempt(ex execer, jobID string, attempt int, workerUrl string) error {
+	_, err := ex.Exec(
+		"INSERT INTO job_attempts (job_id, attempt, worker_url, started_at) VALUES ($1, $2, $3, NOW())",
+		jobID, attempt, workerUrl,
+	)
+	return err
+}
+
+func finishAttempt(jobID string, attempt int, outcome string, errorMessage string) {
+	// Only a running attempt is finished, a late result for an attempt the lease monitor
+	// already timed out leaves its row as it is
+	var errorArg interface{}
+	if errorMessage != "" {
+		errorArg = errorMessage
+	}
+
+	_, err := db.Exec(`
+		UPDATE job_attempts
+		SET outcome = $3, error = $4, finished_at = NOW(), duration_seconds = EXTRACT(EPOCH FROM NOW() - started_at)
+		WHERE job_id = $1 AND attempt = $2 AND outcome = 'running'
+	`, jobID, attempt, outcome, errorArg)
+
+	if err != nil {
+		fmt.Println("Error recording attempt", attempt, "of job", jobID, err)
+	}
+}
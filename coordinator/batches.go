@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// BatchSummary is the aggregate state of a batch once every member has finished,
+// it is the body of the batch.finished webhook sent to the batch's callback_url
+type BatchSummary struct {
+	BatchID        int       `json:"batch_id"`
+	Name           string    `json:"name"`
+	Total          int       `json:"total"`
+	Completed      int       `json:"completed"`
+	Failed         int       `json:"failed"`
+	Cancelled      int       `json:"cancelled"`
+	FinalizedAt    time.Time `json:"finalized_at"`
+	FinalizerJobID *int      `json:"finalizer_job_id"`
+}
+
+// Statuses a job never leaves
+const terminalStatuses = "('completed', 'failed', 'cancelled', 'upstream_failed')"
+
+func batchMonitor() {
+	// Finalize batches whose last member finished without passing through
+	// processJobResults, e.g. a job cancelled through the submitter
+	for {
+		rows, err := db.Query(`
+			SELECT id FROM batches b
+			WHERE finalized_at IS NULL AND NOT EXISTS (
+				SELECT 1 FROM jobs WHERE batch_id = b.id AND status NOT IN ` + terminalStatuses + `
+			)
+		`)
+
+		if err != nil {
+			fmt.Println("Error querying finished batches:", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		var batchIDs []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				fmt.Println("Error scanning batch row:", err)
+				continue
+			}
+			batchIDs = append(batchIDs, id)
+		}
+
+		rows.Close()
+
+		for _, id := range batchIDs {
+			if err := finalizeBatch(id); err != nil {
+				fmt.Println("Error finalizing batch", id, err)
+			}
+		}
+
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func finalizeBatchAfter(jobID string) {
+	// Called once a job reaches a terminal state, the batch is finalized if it was the last member
+	var batchID sql.NullInt64
+	if err := db.QueryRow("SELECT batch_id FROM jobs WHERE id = $1", jobID).Scan(&batchID); err != nil {
+		fmt.Println("Error looking up batch of job", jobID, err)
+		return
+	}
+
+	if !batchID.Valid {
+		return
+	}
+
+	if err := finalizeBatch(int(batchID.Int64)); err != nil {
+		fmt.Println("Error finalizing batch", batchID.Int64, err)
+	}
+}
+
+func finalizeBatch(batchID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// The row lock and the finalized_at guard make sure the finalizer fires only once
+	var summary BatchSummary
+	var finalizerName, finalizerPayload, finalizerPriority, callbackUrl sql.NullString
+
+	err = tx.QueryRow(`
+		SELECT id, name, finalizer_job_name, finalizer_payload, finalizer_priority, callback_url FROM batches
+		WHERE id = $1 AND finalized_at IS NULL
+		FOR UPDATE
+	`, batchID).Scan(&summary.BatchID, &summary.Name, &finalizerName, &finalizerPayload, &finalizerPriority, &callbackUrl)
+
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var active int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE status NOT IN `+terminalStatuses+`),
+			COUNT(*),
+			COUNT(*) FILTER (WHERE status = 'completed'),
+			COUNT(*) FILTER (WHERE status IN ('failed', 'upstream_failed')),
+			COUNT(*) FILTER (WHERE status = 'cancelled')
+		FROM jobs WHERE batch_id = $1
+	`, batchID).Scan(&active, &summary.Total, &summary.Completed, &summary.Failed, &summary.Cancelled)
+
+	if err != nil {
+		return err
+	}
+
+	if active > 0 {
+		return nil
+	}
+
+	// The finalizer job is an ordinary job, it is not a member of the batch it finalizes
+	var finalizer Job
+	if finalizerName.Valid {
+		finalizer = Job{Name: finalizerName.String, Payload: finalizerPayload.String, Priority: finalizerPriority.String}
+		if !isValidPriority(finalizer.Priority) {
+			finalizer.Priority = DEFAULT_PRIORITY
+		}
+
+		var finalizerID int
+		err = tx.QueryRow(
+			"INSERT INTO jobs (name, payload, priority) VALUES ($1, $2, $3) RETURNING id",
+			finalizer.Name, finalizer.Payload, finalizer.Priority,
+		).Scan(&finalizerID)
+		if err != nil {
+			return err
+		}
+
+		finalizer.ID = strconv.Itoa(finalizerID)
+		summary.FinalizerJobID = &finalizerID
+	}
+
+	summary.FinalizedAt = time.Now().UTC()
+	_, err = tx.Exec(
+		"UPDATE batches SET finalized_at = $1, finalizer_job_id = $2 WHERE id = $3",
+		summary.FinalizedAt, summary.FinalizerJobID, batchID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if callbackUrl.Valid {
+		if err := addWebhookDelivery(tx, nil, callbackUrl.String, "batch.finished", summary); err != nil {
+			return err
+		}
+	}
+
+	var queued []Job
+	if summary.FinalizerJobID != nil {
+		queued = append(queued, finalizer)
+	}
+	if err := commitAndEnqueue(tx, queued...); err != nil {
+		return err
+	}
+
+	fmt.Printf("Batch %d finished: %d completed, %d failed, %d cancelled\n",
+		batchID, summary.Completed, summary.Failed, summary.Cancelled)
+	return nil
+}
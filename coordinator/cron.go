@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed 5 field cron expression: minute hour day-of-month month day-of-week
+type CronSchedule struct {
+	minutes     map[int]bool
+	hours       map[int]bool
+	daysOfMonth map[int]bool
+	months      map[int]bool
+	daysOfWeek  map[int]bool
+
+	// Standard cron matches either day field when both are restricted, a field starting
+	// with * (including */n) is not restricted
+	domRestricted bool
+	dowRestricted bool
+
+	// Only schedules at fixed hours skip the repeated hour of a fall-back, interval
+	// schedules keep running on real time through it
+	hourRestricted bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func parseCron(expression string) (*CronSchedule, error) {
+	expression = strings.TrimSpace(expression)
+	if macro, ok := cronMacros[expression]; ok {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields, got %d", len(fields))
+	}
+
+	var schedule CronSchedule
+	var err error
+
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %v", err)
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %v", err)
+	}
+	if schedule.daysOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %v", err)
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %v", err)
+	}
+	if schedule.daysOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %v", err)
+	}
+
+	// Both 0 and 7 mean Sunday
+	if schedule.daysOfWeek[7] {
+		schedule.daysOfWeek[0] = true
+	}
+
+	schedule.domRestricted = !strings.HasPrefix(fields[2], "*")
+	schedule.dowRestricted = !strings.HasPrefix(fields[4], "*")
+	schedule.hourRestricted = !strings.HasPrefix(fields[1], "*")
+
+	return &schedule, nil
+}
+
+func parseCronField(field string, min int, max int) (map[int]bool, error) {
+	// A field is a comma separated list of *, n, a-b with an optional /step
+	values := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		start, end := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err1, err2 error
+			start, err1 = strconv.Atoi(bounds[0])
+			end, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid range %q", rangePart)
+			}
+		default:
+			value, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", rangePart)
+			}
+			start, end = value, value
+			// n/step means every step starting at n
+			if step > 1 {
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+
+	return values, nil
+}
+
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.daysOfMonth[t.Day()]
+	dowMatch := c.daysOfWeek[int(t.Weekday())]
+
+	if c.domRestricted && c.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// Next returns the first matching time strictly after the given time, evaluated in
+// the location of that time. A zero time is returned if nothing matches within 5 years.
+// Within a day the search steps in absolute time, so it always moves forward across DST
+// changes: wall times skipped by a spring-forward never fire, and wall times repeated by
+// a fall-back fire only on their first occurrence when the hour field is restricted
+func (c *CronSchedule) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !c.months[int(t.Month())] {
+			t = startOfDay(t.Year(), t.Month()+1, 1, loc)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = startOfDay(t.Year(), t.Month(), t.Day()+1, loc)
+			continue
+		}
+		if !c.hours[t.Hour()] {
+			// Step to the next wall clock hour by the minutes left in this one
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if !c.minutes[t.Minute()] || (c.hourRestricted && repeatedWallTime(t)) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+func startOfDay(year int, month time.Month, day int, loc *time.Location) time.Time {
+	// The first instant of the date, midnight itself does not exist where a DST change
+	// happens at midnight. Noon normalizes the date, e.g. day 32 to the next month
+	noon := time.Date(year, month, day, 12, 0, 0, 0, loc)
+	t := time.Date(noon.Year(), noon.Month(), noon.Day(), 0, 0, 0, 0, loc)
+	for t.Day() != noon.Day() {
+		t = t.Add(time.Hour)
+	}
+	return t
+}
+
+func repeatedWallTime(t time.Time) bool {
+	// After a fall-back the clock shows the same wall time twice, t is the second
+	// occurrence if shifting it back by the offset change shows the same wall time
+	_, offset := t.Zone()
+	_, earlierOffset := t.Add(-12 * time.Hour).Zone()
+	if earlierOffset <= offset {
+		return false
+	}
+
+	earlier := t.Add(-time.Duration(earlierOffset-offset) * time.Second)
+	return earlier.Hour() == t.Hour() && earlier.Minute() == t.Minute() && earlier.Day() == t.Day()
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expression string
+		wantErr    bool
+	}{
+		{"* * * * *", false},
+		{"*/15 9-17 * * 1-5", false},
+		{"0 0 1,15 * *", false},
+		{"5/10 * * * *", false},
+		{"0 0 * * 7", false},
+		{"@daily", false},
+		{" @hourly ", false},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"*/0 * * * *", true},
+		{"5-1 * * * *", true},
+		{"a * * * *", true},
+		{"1-x * * * *", true},
+	}
+
+	for _, tt := range tests {
+		_, err := parseCron(tt.expression)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCron(%q) error = %v, wantErr %v", tt.expression, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseCronDayRestriction(t *testing.T) {
+	tests := []struct {
+		expression    string
+		domRestricted bool
+		dowRestricted bool
+	}{
+		{"0 0 * * *", false, false},
+		{"0 0 */1 * */1", false, false},
+		{"0 0 */2 * 1", false, true},
+		{"0 0 1 * *", true, false},
+		{"0 0 1 * 1", true, true},
+	}
+
+	for _, tt := range tests {
+		cron, err := parseCron(tt.expression)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expression, err)
+		}
+		if cron.domRestricted != tt.domRestricted || cron.dowRestricted != tt.dowRestricted {
+			t.Errorf("parseCron(%q) restricted = %v/%v, want %v/%v",
+				tt.expression, cron.domRestricted, cron.dowRestricted, tt.domRestricted, tt.dowRestricted)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(loc *time.Location, layout string) time.Time {
+		value, err := time.ParseInLocation("2006-01-02 15:04 MST", layout, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		after      time.Time
+		want       time.Time
+	}{
+		{
+			name:       "next minute",
+			expression: "* * * * *",
+			after:      time.Date(2026, 1, 1, 10, 0, 30, 0, time.UTC),
+			want:       time.Date(2026, 1, 1, 10, 1, 0, 0, time.UTC),
+		},
+		{
+			name:       "strictly after",
+			expression: "30 2 * * *",
+			after:      time.Date(2026, 1, 1, 2, 30, 0, 0, time.UTC),
+			want:       time.Date(2026, 1, 2, 2, 30, 0, 0, time.UTC),
+		},
+		{
+			name:       "month rollover",
+			expression: "0 0 1 * *",
+			after:      time.Date(2026, 12, 15, 0, 0, 0, 0, time.UTC),
+			want:       time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "day of month or day of week",
+			expression: "0 9 15 * 1",
+			after:      time.Date(2026, 6, 9, 10, 0, 0, 0, time.UTC), // Tuesday
+			want:       time.Date(2026, 6, 15, 9, 0, 0, 0, time.UTC), // Monday 15th
+		},
+		{
+			name:       "day of week with step is unrestricted",
+			expression: "0 9 20 * */1",
+			after:      time.Date(2026, 6, 9, 10, 0, 0, 0, time.UTC),
+			want:       time.Date(2026, 6, 20, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "leap day",
+			expression: "0 0 29 2 *",
+			after:      time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			want:       time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "spring forward skips the missing wall time",
+			expression: "30 2 * * *",
+			after:      at(newYork, "2026-03-07 03:00 EST"),
+			want:       at(newYork, "2026-03-09 02:30 EDT"),
+		},
+		{
+			name:       "spring forward hourly",
+			expression: "0 * * * *",
+			after:      at(newYork, "2026-03-08 01:30 EST"),
+			want:       at(newYork, "2026-03-08 03:00 EDT"),
+		},
+		{
+			name:       "fall back fires on the first occurrence",
+			expression: "30 1 * * *",
+			after:      at(newYork, "2026-11-01 00:00 EDT"),
+			want:       at(newYork, "2026-11-01 01:30 EDT"),
+		},
+		{
+			name:       "fall back does not fire twice",
+			expression: "30 1 * * *",
+			after:      at(newYork, "2026-11-01 01:30 EDT"),
+			want:       at(newYork, "2026-11-02 01:30 EST"),
+		},
+		{
+			name:       "fall back hourly runs through the repeated hour",
+			expression: "0 * * * *",
+			after:      at(newYork, "2026-11-01 01:00 EDT"),
+			want:       at(newYork, "2026-11-01 01:00 EST"),
+		},
+		{
+			name:       "fall back interval runs through the repeated hour",
+			expression: "*/30 * * * *",
+			after:      at(newYork, "2026-11-01 01:30 EDT"),
+			want:       at(newYork, "2026-11-01 01:00 EST"),
+		},
+		{
+			name:       "fall back hour step runs through the repeated hour",
+			expression: "30 */1 * * *",
+			after:      at(newYork, "2026-11-01 01:30 EDT"),
+			want:       at(newYork, "2026-11-01 01:30 EST"),
+		},
+		{
+			name:       "fall back hour range fires once",
+			expression: "30 0-3 * * *",
+			after:      at(newYork, "2026-11-01 01:30 EDT"),
+			want:       at(newYork, "2026-11-01 02:30 EST"),
+		},
+		{
+			name:       "missing midnight",
+			expression: "0 0 * * *",
+			after:      time.Date(2026, 9, 5, 12, 0, 0, 0, santiago),
+			want:       time.Date(2026, 9, 7, 0, 0, 0, 0, santiago),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := parseCron(tt.expression)
+			if err != nil {
+				t.Fatalf("parseCron(%q): %v", tt.expression, err)
+			}
+
+			done := make(chan time.Time, 1)
+			go func() { done <- cron.Next(tt.after) }()
+
+			select {
+			case got := <-done:
+				if !got.Equal(tt.want) {
+					t.Errorf("Next(%v) = %v, want %v", tt.after, got, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Next(%v) did not return", tt.after)
+			}
+		})
+	}
+}
+
+func TestCronNextNeverMatches(t *testing.T) {
+	cron, err := parseCron("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cron.Next(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("Next = %v, want zero time", got)
+	}
+}
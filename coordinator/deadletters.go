@@ -0,0 +1,162 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeadLetter is a job that exhausted its retries, stored with the context needed to
+// inspect and replay it
+type DeadLetter struct {
+	ID          int        `json:"id"`
+	JobID       int        `json:"job_id"`
+	Name        string     `json:"name"`
+	Payload     *string    `json:"payload"`
+	Priority    string     `json:"priority"`
+	Reason      string     `json:"reason"` // max_retries_exceeded or lease_timeout
+	LastError   *string    `json:"last_error"`
+	Attempts    int        `json:"attempts"`
+	WorkerUrl   *string    `json:"worker_url"`
+	FailedAt    time.Time  `json:"failed_at"`
+	ReplayedAt  *time.Time `json:"replayed_at"`
+	ReplayCount int        `json:"replay_count"`
+}
+
+const deadLetterColumns = `id, job_id, name, payload, priority, reason, last_error, attempts, worker_url,
+	failed_at, replayed_at, replay_count`
+
+// Returned when a dead letter was already replayed or its job is no longer failed
+var errNotReplayable = errors.New("dead letter cannot be replayed")
+
+func scanDeadLetter(row rowScanner) (DeadLetter, error) {
+	var d DeadLetter
+	err := row.Scan(
+		&d.ID, &d.JobID, &d.Name, &d.Payload, &d.Priority, &d.Reason, &d.LastError, &d.Attempts, &d.WorkerUrl,
+		&d.FailedAt, &d.ReplayedAt, &d.ReplayCount,
+	)
+	return d, err
+}
+
+func recordDeadLetter(jobID string, jobResult map[string]interface{}, reason string) (int, error) {
+	// Copy the job as it was when it died, replaying it later resets the job row
+	var lastError, workerUrl *string
+	if value, ok := jobResult["error"].(string); ok && value != "" {
+		lastError = &value
+	}
+	if value, ok := jobResult["worker_url"].(string); ok && value != "" {
+		workerUrl = &value
+	}
+
+	var deadLetterID int
+	err := db.QueryRow(`
+		INSERT INTO dead_letters (job_id, name, payload, priority, reason, last_error, attempts, worker_url, failed_at)
+		SELECT id, name, payload, priority, $2, $3, retries + 1, $4, $5 FROM jobs WHERE id = $1
+		RETURNING id
+	`, jobID, reason, lastError, workerUrl, time.Now().UTC()).Scan(&deadLetterID)
+
+	return deadLetterID, err
+}
+
+func replayDeadLetter(deadLetterID int) (Job, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Job{}, err
+	}
+
+	defer tx.Rollback()
+
+	var jobID int
+	err = tx.QueryRow(
+		"SELECT job_id FROM dead_letters WHERE id = $1 AND replayed_at IS NULL FOR UPDATE", deadLetterID,
+	).Scan(&jobID)
+
+	if err == sql.ErrNoRows {
+		return Job{}, errNotReplayable
+	}
+	if err != nil {
+		return Job{}, err
+	}
+
+	// Start the job over with a fresh set of retries
+	var job Job
+	err = transitionJob(tx, JobTransition{
+		From:   []string{"failed"},
+		To:     "pending",
+		Reason: "replayed",
+		Set: `retries = 0, next_attempt_at = NULL, lease_start = NULL, lease_renewed_at = NULL, lease_timeout = NULL,
+			leased_to_worker = NULL, completed_at = NULL, result = NULL`,
+		Where: "id = $4",
+		Args:  []interface{}{jobID},
+	}, "id, name, payload, priority", &job.ID, &job.Name, &job.Payload, &job.Priority)
+
+	if err == sql.ErrNoRows {
+		return Job{}, errNotReplayable
+	}
+	if err != nil {
+		return Job{}, err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE dead_letters SET replayed_at = NOW(), replay_count = replay_count + 1 WHERE id = $1", deadLetterID,
+	)
+	if err != nil {
+		return Job{}, err
+	}
+
+	if err := commitAndEnqueue(tx, job); err != nil {
+		return Job{}, err
+	}
+
+	fmt.Println("Replayed dead letter", deadLetterID, "as job", job.ID)
+	publishJobEvent("replayed", job.ID, job.Name, "")
+	return job, nil
+}
+
+func deadLetterFilter(query url.Values) (string, []interface{}, error) {
+	// Build a WHERE clause from the name, reason, replayed and failed_before filters
+	var conditions []string
+	var args []interface{}
+
+	addCondition := func(clause string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(clause, len(args)))
+	}
+
+	if name := query.Get("name"); name != "" {
+		addCondition("name = $%d", name)
+	}
+
+	if reason := query.Get("reason"); reason != "" {
+		addCondition("reason = $%d", reason)
+	}
+
+	if replayed := query.Get("replayed"); replayed != "" {
+		value, err := strconv.ParseBool(replayed)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid replayed filter, expected true or false")
+		}
+		if value {
+			conditions = append(conditions, "replayed_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "replayed_at IS NULL")
+		}
+	}
+
+	if failedBefore := query.Get("failed_before"); failedBefore != "" {
+		value, err := time.Parse(time.RFC3339, failedBefore)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid failed_before, expected RFC3339 timestamp")
+		}
+		addCondition("failed_at < $%d", value.UTC())
+	}
+
+	if len(conditions) == 0 {
+		return "", args, nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dead letters are escalated through the rules in the escalation_rules table. A rule
+// matches a job name, or every job when job_name is NULL, and sends alerts to one sink.
+// Identical failures (same name, reason and error) are grouped per rule: the first one
+// alerts right away, the rest are counted and reported in one summary once the rule's
+// group window has passed
+
+// Alert is what a sink receives, either for the first failure of a group or as the
+// summary of the failures counted since
+type Alert struct {
+	Kind            string    `json:"kind"` // first or summary
+	RuleID          int       `json:"rule_id"`
+	JobName         string    `json:"job_name"`
+	Reason          string    `json:"reason"`
+	LastError       string    `json:"last_error"`
+	Occurrences     int       `json:"occurrences"`
+	JobID           string    `json:"job_id"`
+	DeadLetterID    int       `json:"dead_letter_id"`
+	WindowStartedAt time.Time `json:"window_started_at"`
+}
+
+// EscalationRule is a row of the escalation_rules table
+type EscalationRule struct {
+	ID                 int     `json:"id"`
+	JobName            *string `json:"job_name"`
+	Sink               string  `json:"sink"`   // webhook, file or smtp
+	Target             string  `json:"target"` // URL, file path under ESCALATION_FILE_DIR or email address
+	GroupWindowSeconds int     `json:"group_window_seconds"`
+}
+
+// EscalationSink delivers alerts to one destination
+type EscalationSink interface {
+	Send(alert Alert) error
+}
+
+// Sinks by the name used in escalation_rules.sink, each built from the rule's target
+var escalationSinks = map[string]func(target string) EscalationSink{
+	"webhook": func(target string) EscalationSink { return webhookSink{url: target} },
+	"file":    func(target string) EscalationSink { return fileSink{path: target} },
+	"smtp":    func(target string) EscalationSink { return smtpSink{to: target} },
+}
+
+const DEFAULT_GROUP_WINDOW_SECONDS = 300
+
+// webhookSink queues the alert as a signed webhook, retried like job webhooks
+type webhookSink struct {
+	url string
+}
+
+func (s webhookSink) Send(alert Alert) error {
+	return addWebhookDelivery(db, nil, s.url, "dead_letter."+alert.Kind, alert)
+}
+
+// fileSink appends the alert as one JSON line to an audit log in ESCALATION_FILE_DIR
+type fileSink struct {
+	path string
+}
+
+// Serializes appends from concurrent escalations to the same file
+var fileSinkMutex sync.Mutex
+
+func (s fileSink) Send(alert Alert) error {
+	line, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	// Checked again on every alert, the directory or its links may have changed since
+	// the rule was created
+	path, err := resolveEscalationFile(os.Getenv("ESCALATION_FILE_DIR"), s.path)
+	if err != nil {
+		return err
+	}
+
+	fileSinkMutex.Lock()
+	defer fileSinkMutex.Unlock()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// smtpSink mails the alert through the relay in SMTP_ADDR, sent from SMTP_FROM
+type smtpSink struct {
+	to string
+}
+
+func (s smtpSink) Send(alert Alert) error {
+	addr, from := os.Getenv("SMTP_ADDR"), os.Getenv("SMTP_FROM")
+	if addr == "" || from == "" {
+		return fmt.Errorf("SMTP_ADDR and SMTP_FROM must be set for the smtp sink")
+	}
+
+	// The target was checked when the rule was created, parsing it again keeps a line
+	// break out of the To header of rules stored before that check
+	to, err := mail.ParseAddress(s.to)
+	if err != nil {
+		return fmt.Errorf("invalid smtp target %q: %v", s.to, err)
+	}
+
+	return smtp.SendMail(addr, nil, from, []string{to.Address}, []byte(smtpMessage(from, to, alert)))
+}
+
+func smtpMessage(from string, to *mail.Address, alert Alert) string {
+	// Job names come from the submitter and reasons carry worker errors, the subject is
+	// Q-encoded so a line break in either cannot start a new header or the body
+	subject := fmt.Sprintf("[job scheduler] %s failed: %s", alert.JobName, alert.Reason)
+	if alert.Kind == "summary" {
+		subject = fmt.Sprintf("[job scheduler] %s failed %d more times: %s", alert.JobName, alert.Occurrences, alert.Reason)
+	}
+
+	body, _ := json.MarshalIndent(alert, "", "  ")
+	return "From: " + from + "\r\n" +
+		"To: " + to.String() + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"Content-Type: application/json\r\n" +
+		"\r\n" + string(body) + "\r\n"
+}
+
+func resolveEscalationFile(dir string, target string) (string, error) {
+	// Rules are created over the API, so a file sink may only write inside the directory
+	// the coordinator was configured with. Relative targets are taken from that directory,
+	// links are resolved before the check so they cannot point out of it
+	if dir == "" {
+		return "", fmt.Errorf("ESCALATION_FILE_DIR must be set for the file sink")
+	}
+
+	root, err := filepath.Abs(dir)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return "", fmt.Errorf("escalation file directory: %w", err)
+	}
+
+	path := target
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(filepath.Clean(path)))
+	if err != nil {
+		return "", fmt.Errorf("escalation file directory: %w", err)
+	}
+	path = filepath.Join(parent, filepath.Base(path))
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("target must be a file inside %s", root)
+	}
+
+	// The file itself may not be a link either
+	if info, err := os.Lstat(path); err == nil && !info.Mode().IsRegular() {
+		return "", fmt.Errorf("target must be a regular file")
+	}
+	return path, nil
+}
+
+func validateEscalationRule(rule *EscalationRule) error {
+	if escalationSinks[rule.Sink] == nil {
+		return fmt.Errorf("sink must be one of webhook, file or smtp")
+	}
+	if rule.Target == "" {
+		return fmt.Errorf("target is required")
+	}
+	if rule.Sink == "webhook" && webhookSecret == "" {
+		return errNoWebhookSecret
+	}
+	if rule.Sink == "smtp" {
+		if _, err := mail.ParseAddress(rule.Target); err != nil {
+			return fmt.Errorf("target must be an email address: %v", err)
+		}
+	}
+	if rule.Sink == "file" {
+		if _, err := resolveEscalationFile(os.Getenv("ESCALATION_FILE_DIR"), rule.Target); err != nil {
+			return err
+		}
+	}
+	if rule.GroupWindowSeconds <= 0 {
+		return fmt.Errorf("group_window_seconds must be positive")
+	}
+	return nil
+}
+
+func escalateDeadLetter(dqlMessage string) {
+	var message struct {
+		DeadLetterID int                    `json:"dead_letter_id"`
+		JobID        string                 `json:"job_id"`
+		Reason       string                 `json:"reason"`
+		OriginalJob  map[string]interface{} `json:"original_job"`
+	}
+
+	if err := json.Unmarshal([]byte(dqlMessage), &message); err != nil {
+		fmt.Println("Error parsing DQL message:", err)
+		return
+	}
+
+	var jobName string
+	if err := db.QueryRow("SELECT name FROM jobs WHERE id = $1", message.JobID).Scan(&jobName); err != nil {
+		fmt.Println("Error looking up dead job", message.JobID, err)
+		return
+	}
+
+	lastError, _ := message.OriginalJob["error"].(string)
+
+	rows, err := db.Query(`
+		SELECT id, job_name, sink, target, group_window_seconds FROM escalation_rules
+		WHERE job_name IS NULL OR job_name = $1
+		ORDER BY id
+	`, jobName)
+
+	if err != nil {
+		fmt.Println("Error loading escalation rules:", err)
+		return
+	}
+
+	var rules []EscalationRule
+	for rows.Next() {
+		var rule EscalationRule
+		if err := rows.Scan(&rule.ID, &rule.JobName, &rule.Sink, &rule.Target, &rule.GroupWindowSeconds); err != nil {
+			fmt.Println("Error scanning escalation rule row:", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	rows.Close()
+
+	// Failures are identical when name, reason and error match
+	groupKey := strings.Join([]string{jobName, message.Reason, lastError}, "\x1f")
+
+	for _, rule := range rules {
+		alert := Alert{
+			Kind:         "first",
+			RuleID:       rule.ID,
+			JobName:      jobName,
+			Reason:       message.Reason,
+			LastError:    lastError,
+			Occurrences:  1,
+			JobID:        message.JobID,
+			DeadLetterID: message.DeadLetterID,
+		}
+		sample, _ := json.Marshal(alert)
+
+		// Only the failure that opens the group alerts now, the upsert is atomic so two
+		// coordinators cannot both open it
+		var occurrences int
+		err := db.QueryRow(`
+			INSERT INTO escalation_groups (rule_id, group_key, window_started_at, occurrences, reported, sample)
+			VALUES ($1, $2, $3, 1, 1, $4)
+			ON CONFLICT (rule_id, group_key)
+			DO UPDATE SET occurrences = escalation_groups.occurrences + 1, sample = EXCLUDED.sample
+			RETURNING occurrences, window_started_at
+		`, rule.ID, groupKey, time.Now().UTC(), string(sample)).Scan(&occurrences, &alert.WindowStartedAt)
+
+		if err != nil {
+			fmt.Println("Error grouping dead letter for rule", rule.ID, err)
+			continue
+		}
+
+		if occurrences > 1 {
+			continue
+		}
+
+		sendAlert(rule, alert)
+	}
+}
+
+func flushEscalationGroups() {
+	// Close groups whose window has passed and report the failures counted since the
+	// first alert. The next failure of the same kind opens a new group and alerts again
+	for {
+		rows, err := db.Query(`
+			DELETE FROM escalation_groups
+			WHERE (rule_id, group_key) IN (
+				SELECT g.rule_id, g.group_key FROM escalation_groups g
+				JOIN escalation_rules r ON r.id = g.rule_id
+				WHERE g.window_started_at + r.group_window_seconds * interval '1 second' <= $1
+				FOR UPDATE OF g SKIP LOCKED
+			)
+			RETURNING rule_id, occurrences - reported, window_started_at, sample
+		`, time.Now().UTC())
+
+		if err != nil {
+			fmt.Println("Error flushing escalation groups:", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		var alerts []Alert
+		for rows.Next() {
+			var alert Alert
+			var sample string
+			var windowStartedAt time.Time
+			if err := rows.Scan(&alert.RuleID, &alert.Occurrences, &windowStartedAt, &sample); err != nil {
+				fmt.Println("Error scanning escalation group row:", err)
+				continue
+			}
+
+			occurrences := alert.Occurrences
+			json.Unmarshal([]byte(sample), &alert)
+			alert.Kind, alert.Occurrences, alert.WindowStartedAt = "summary", occurrences, windowStartedAt
+
+			if alert.Occurrences > 0 {
+				alerts = append(alerts, alert)
+			}
+		}
+
+		rows.Close()
+
+		for _, alert := range alerts {
+			rule, err := loadEscalationRule(alert.RuleID)
+			if err != nil {
+				fmt.Println("Error loading escalation rule", alert.RuleID, err)
+				continue
+			}
+			sendAlert(rule, alert)
+		}
+
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func loadEscalationRule(ruleID int) (EscalationRule, error) {
+	var rule EscalationRule
+	err := db.QueryRow(
+		"SELECT id, job_name, sink, target, group_window_seconds FROM escalation_rules WHERE id = $1", ruleID,
+	).Scan(&rule.ID, &rule.JobName, &rule.Sink, &rule.Target, &rule.GroupWindowSeconds)
+
+	if err == sql.ErrNoRows {
+		return rule, fmt.Errorf("rule was deleted")
+	}
+	return rule, err
+}
+
+func sendAlert(rule EscalationRule, alert Alert) {
+	newSink := escalationSinks[rule.Sink]
+	if newSink == nil {
+		fmt.Println("Unknown escalation sink", rule.Sink, "in rule", rule.ID)
+		return
+	}
+
+	if err := newSink(rule.Target).Send(alert); err != nil {
+		fmt.Println("Error escalating", alert.JobName, "through", rule.Sink, "sink of rule", rule.ID, err)
+		return
+	}
+
+	fmt.Println("Escalated", alert.Kind, "alert for", alert.JobName, "through", rule.Sink, "sink of rule", rule.ID)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveEscalationFile(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+
+	if err := os.Mkdir(filepath.Join(dir, "audit"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "passwd"), filepath.Join(dir, "link.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target  string
+		want    string
+		wantErr bool
+	}{
+		{"dead_letters.jsonl", filepath.Join(dir, "dead_letters.jsonl"), false},
+		{"audit/dead_letters.jsonl", filepath.Join(dir, "audit", "dead_letters.jsonl"), false},
+		{filepath.Join(dir, "dead_letters.jsonl"), filepath.Join(dir, "dead_letters.jsonl"), false},
+		{"audit/../dead_letters.jsonl", filepath.Join(dir, "dead_letters.jsonl"), false},
+		{"../dead_letters.jsonl", "", true},
+		{"/etc/passwd", "", true},
+		{filepath.Join(outside, "dead_letters.jsonl"), "", true},
+		{"escape/dead_letters.jsonl", "", true},
+		{"link.jsonl", "", true},
+		{"missing/dead_letters.jsonl", "", true},
+		{".", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := resolveEscalationFile(dir, tt.target)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("resolveEscalationFile(%q) = %q, %v, want %q, wantErr %v", tt.target, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	if _, err := resolveEscalationFile("", "dead_letters.jsonl"); err == nil {
+		t.Error("resolveEscalationFile without a directory should fail")
+	}
+}
+
+func TestSmtpMessageHeaders(t *testing.T) {
+	to := &mail.Address{Address: "oncall@example.com"}
+	alert := Alert{
+		Kind:    "first",
+		JobName: "network_task\r\nBcc: attacker@example.com",
+		Reason:  "failed\r\n\r\nforged body",
+	}
+
+	message := smtpMessage("scheduler@example.com", to, alert)
+	headers, _, found := strings.Cut(message, "\r\n\r\n")
+	if !found {
+		t.Fatalf("message has no header end: %q", message)
+	}
+
+	lines := strings.Split(headers, "\r\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 header lines, got %q", lines)
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "Bcc:") || strings.Contains(line, "\n") {
+			t.Errorf("injected header line %q", line)
+		}
+	}
+	if !strings.HasPrefix(lines[2], "Subject: =?utf-8?q?") {
+		t.Errorf("subject with line breaks not encoded: %q", lines[2])
+	}
+}
+
+func TestValidateEscalationRuleSmtpTarget(t *testing.T) {
+	tests := []struct {
+		target  string
+		wantErr bool
+	}{
+		{"oncall@example.com", false},
+		{"On Call <oncall@example.com>", false},
+		{"oncall@example.com\r\nBcc: attacker@example.com", true},
+		{"not an address", true},
+	}
+
+	for _, tt := range tests {
+		rule := EscalationRule{Sink: "smtp", Target: tt.target, GroupWindowSeconds: DEFAULT_GROUP_WINDOW_SECONDS}
+		if err := validateEscalationRule(&rule); (err != nil) != tt.wantErr {
+			t.Errorf("validateEscalationRule(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateEscalationRuleWebhookNeedsSecret(t *testing.T) {
+	defer func(secret string) { webhookSecret = secret }(webhookSecret)
+	rule := EscalationRule{Sink: "webhook", Target: "http://example.com/alerts", GroupWindowSeconds: DEFAULT_GROUP_WINDOW_SECONDS}
+
+	webhookSecret = ""
+	if err := validateEscalationRule(&rule); err != errNoWebhookSecret {
+		t.Errorf("without a secret: error = %v, want errNoWebhookSecret", err)
+	}
+
+	webhookSecret = "secret"
+	if err := validateEscalationRule(&rule); err != nil {
+		t.Errorf("with a secret: unexpected error %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Job and worker events are published on a Redis channel by every coordinator and the
+// submitter, GET /events relays them to clients as server-sent events
+const EVENTS_CHANNEL = "job_events"
+
+// Event is a single job state transition or worker state change
+type Event struct {
+	Type      string    `json:"type"`  // job or worker
+	Event     string    `json:"event"` // submitted, leased, completed, failed, retried, dead_lettered, replayed, cancelled or worker_state
+	JobID     string    `json:"job_id,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	WorkerUrl string    `json:"worker_url,omitempty"`
+	State     string    `json:"state,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+func publishJobEvent(event string, jobID string, name string, workerUrl string) {
+	publishEvent(Event{Type: "job", Event: event, JobID: jobID, Name: name, WorkerUrl: workerUrl})
+}
+
+func publishWorkerEvent(workerUrl string, state string) {
+	publishEvent(Event{Type: "worker", Event: "worker_state", WorkerUrl: workerUrl, State: state})
+}
+
+func publishEvent(event Event) {
+	// Events are best effort, nobody may be listening and a lost event is not retried
+	event.Time = time.Now().UTC()
+	eventJson, _ := json.Marshal(event)
+
+	if err := redisClient.Publish(EVENTS_CHANNEL, eventJson).Err(); err != nil {
+		fmt.Println("Error publishing event:", err)
+	}
+}
+
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Optional filters, an event has to match all of them. Worker events carry no job,
+	// so filtering by job_id or name only streams job events
+	query := r.URL.Query()
+	jobID, name, worker := query.Get("job_id"), query.Get("name"), query.Get("worker")
+
+	pubsub := redisClient.Subscribe(EVENTS_CHANNEL)
+	defer pubsub.Close()
+
+	// Wait for the subscription to be confirmed so no event published after the
+	// response starts is missed
+	if _, err := pubsub.Receive(); err != nil {
+		http.Error(w, "Failed to subscribe to events", http.StatusInternalServerError)
+		fmt.Println("Error subscribing to events:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	messages := pubsub.Channel()
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			// Comment line, keeps proxies from closing an idle stream
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			var event Event
+			if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+				continue
+			}
+
+			if (jobID != "" && event.JobID != jobID) ||
+				(name != "" && event.Name != name) ||
+				(worker != "" && event.WorkerUrl != worker) {
+				continue
+			}
+
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Event, message.Payload)
+			flusher.Flush()
+		}
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 func registerWorkerHandler(w http.ResponseWriter, r *http.Request) {
@@ -36,3 +42,824 @@ func registerWorkerHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Worker registered successfully"))
 
 }
+
+func cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobID := r.PathValue("id")
+	id, err := strconv.Atoi(jobID)
+	if err != nil {
+		http.Error(w, "Invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	previousStatus, err := cancelJob(jobID)
+	if err == sql.ErrNoRows {
+		var status string
+		err = db.QueryRow("SELECT status FROM jobs WHERE id = $1", jobID).Scan(&status)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		if err == nil {
+			http.Error(w, "Job is already "+status, http.StatusConflict)
+			return
+		}
+	}
+
+	if err != nil {
+		http.Error(w, "Failed to cancel job", http.StatusInternalServerError)
+		fmt.Println("Error cancelling job", jobID, err)
+		return
+	}
+
+	fmt.Println("Cancelled job", jobID, "previous status:", previousStatus)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":              id,
+		"status":          "cancelled",
+		"previous_status": previousStatus,
+	})
+}
+
+func renewLeaseHandler(w http.ResponseWriter, r *http.Request) {
+	jobID := r.PathValue("job_id")
+	if _, err := strconv.Atoi(jobID); err != nil {
+		http.Error(w, "Invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	var payload struct {
+		WorkerUrl  string `json:"worker_url"`
+		LeaseToken int    `json:"lease_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.WorkerUrl == "" {
+		http.Error(w, "Invalid request payload, worker_url is required", http.StatusBadRequest)
+		return
+	}
+
+	expiresAt, err := renewLease(jobID, payload.WorkerUrl, payload.LeaseToken)
+	if err == errLeaseNotHeld {
+		// Cancelled, finished, expired or leased to another worker, the worker should stop renewing
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to renew lease", http.StatusInternalServerError)
+		fmt.Println("Error renewing lease for job:", jobID, err)
+		return
+	}
+
+	leaseRenewals.Inc()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"job_id":     jobID,
+		"expires_at": expiresAt,
+	})
+}
+
+func reconcileHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, reconcile("admin"))
+}
+
+func createScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	// Defaults for fields the request leaves out
+	schedule := Schedule{
+		Timezone:        "UTC",
+		Priority:        DEFAULT_PRIORITY,
+		Enabled:         true,
+		MissedRunPolicy: "skip",
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateSchedule(&schedule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	nextRunAt, err := nextScheduleRun(schedule, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedule, err = scanSchedule(db.QueryRow(`
+		INSERT INTO schedules (name, cron_expression, timezone, job_name, payload_template, priority,
+			enabled, missed_run_policy, allow_overlap, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING `+scheduleColumns,
+		schedule.Name, schedule.CronExpression, schedule.Timezone, schedule.JobName, schedule.PayloadTemplate, schedule.Priority,
+		schedule.Enabled, schedule.MissedRunPolicy, schedule.AllowOverlap, nextRunAt,
+	))
+
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			http.Error(w, "Schedule name already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to create schedule", http.StatusInternalServerError)
+		fmt.Println("Error creating schedule:", err)
+		return
+	}
+
+	fmt.Println("Created schedule", schedule.Name, "next run at", nextRunAt)
+	writeJSON(w, http.StatusCreated, schedule)
+}
+
+func listSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query("SELECT " + scheduleColumns + " FROM schedules ORDER BY id")
+	if err != nil {
+		http.Error(w, "Failed to list schedules", http.StatusInternalServerError)
+		fmt.Println("Error listing schedules:", err)
+		return
+	}
+	defer rows.Close()
+
+	schedules := []Schedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			http.Error(w, "Failed to list schedules", http.StatusInternalServerError)
+			fmt.Println("Error scanning schedule row:", err)
+			return
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	writeJSON(w, http.StatusOK, schedules)
+}
+
+func getScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := loadSchedule(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, schedule)
+}
+
+func updateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := loadSchedule(w, r)
+	if !ok {
+		return
+	}
+	scheduleID := schedule.ID
+
+	// Fields present in the request replace the stored ones, e.g. {"enabled": false} pauses
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	schedule.ID = scheduleID
+
+	if err := validateSchedule(&schedule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Runs missed while paused or under the old expression are not caught up
+	nextRunAt, err := nextScheduleRun(schedule, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedule, err = scanSchedule(db.QueryRow(`
+		UPDATE schedules SET name = $1, cron_expression = $2, timezone = $3, job_name = $4, payload_template = $5,
+			priority = $6, enabled = $7, missed_run_policy = $8, allow_overlap = $9, next_run_at = $10
+		WHERE id = $11
+		RETURNING `+scheduleColumns,
+		schedule.Name, schedule.CronExpression, schedule.Timezone, schedule.JobName, schedule.PayloadTemplate,
+		schedule.Priority, schedule.Enabled, schedule.MissedRunPolicy, schedule.AllowOverlap, nextRunAt, schedule.ID,
+	))
+
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			http.Error(w, "Schedule name already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to update schedule", http.StatusInternalServerError)
+		fmt.Println("Error updating schedule:", err)
+		return
+	}
+
+	fmt.Println("Updated schedule", schedule.Name, "enabled:", schedule.Enabled)
+	writeJSON(w, http.StatusOK, schedule)
+}
+
+func deleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid schedule id", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Exec("DELETE FROM schedules WHERE id = $1", scheduleID)
+	if err != nil {
+		http.Error(w, "Failed to delete schedule", http.StatusInternalServerError)
+		fmt.Println("Error deleting schedule:", err)
+		return
+	}
+
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listJobLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query("SELECT name, max_concurrent, max_starts_per_second FROM job_limits ORDER BY name")
+	if err != nil {
+		http.Error(w, "Failed to list limits", http.StatusInternalServerError)
+		fmt.Println("Error listing job limits:", err)
+		return
+	}
+	defer rows.Close()
+
+	limits := []JobLimit{}
+	for rows.Next() {
+		var limit JobLimit
+		if err := rows.Scan(&limit.Name, &limit.MaxConcurrent, &limit.MaxStartsPerSecond); err != nil {
+			http.Error(w, "Failed to list limits", http.StatusInternalServerError)
+			fmt.Println("Error scanning job limit row:", err)
+			return
+		}
+		limits = append(limits, limit)
+	}
+
+	writeJSON(w, http.StatusOK, limits)
+}
+
+func putJobLimitHandler(w http.ResponseWriter, r *http.Request) {
+	var limit JobLimit
+	if err := json.NewDecoder(r.Body).Decode(&limit); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	limit.Name = r.PathValue("name")
+
+	if err := validateJobLimit(&limit); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO job_limits (name, max_concurrent, max_starts_per_second)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name)
+		DO UPDATE SET max_concurrent = EXCLUDED.max_concurrent, max_starts_per_second = EXCLUDED.max_starts_per_second
+	`, limit.Name, limit.MaxConcurrent, limit.MaxStartsPerSecond)
+
+	if err != nil {
+		http.Error(w, "Failed to save limit", http.StatusInternalServerError)
+		fmt.Println("Error saving job limit:", err)
+		return
+	}
+
+	fmt.Println("Set limit for", limit.Name)
+	writeJSON(w, http.StatusOK, limit)
+}
+
+func deleteJobLimitHandler(w http.ResponseWriter, r *http.Request) {
+	res, err := db.Exec("DELETE FROM job_limits WHERE name = $1", r.PathValue("name"))
+	if err != nil {
+		http.Error(w, "Failed to delete limit", http.StatusInternalServerError)
+		fmt.Println("Error deleting job limit:", err)
+		return
+	}
+
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		http.Error(w, "Limit not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var webhook struct {
+		ID      int    `json:"id"`
+		JobName string `json:"job_name"`
+		Url     string `json:"url"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if webhook.JobName == "" {
+		http.Error(w, "job_name is required", http.StatusBadRequest)
+		return
+	}
+	if webhookSecret == "" {
+		http.Error(w, errNoWebhookSecret.Error(), http.StatusBadRequest)
+		return
+	}
+	if parsed, err := url.Parse(webhook.Url); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		http.Error(w, "url must be an http or https URL", http.StatusBadRequest)
+		return
+	}
+
+	err := db.QueryRow(
+		"INSERT INTO job_webhooks (job_name, url) VALUES ($1, $2) RETURNING id",
+		webhook.JobName, webhook.Url,
+	).Scan(&webhook.ID)
+
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			http.Error(w, "Webhook already registered", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		fmt.Println("Error creating webhook:", err)
+		return
+	}
+
+	fmt.Println("Registered webhook", webhook.Url, "for", webhook.JobName)
+	writeJSON(w, http.StatusCreated, webhook)
+}
+
+func listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query("SELECT id, job_name, url, created_at FROM job_webhooks ORDER BY id")
+	if err != nil {
+		http.Error(w, "Failed to list webhooks", http.StatusInternalServerError)
+		fmt.Println("Error listing webhooks:", err)
+		return
+	}
+	defer rows.Close()
+
+	type webhook struct {
+		ID        int       `json:"id"`
+		JobName   string    `json:"job_name"`
+		Url       string    `json:"url"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	webhooks := []webhook{}
+	for rows.Next() {
+		var hook webhook
+		if err := rows.Scan(&hook.ID, &hook.JobName, &hook.Url, &hook.CreatedAt); err != nil {
+			http.Error(w, "Failed to list webhooks", http.StatusInternalServerError)
+			fmt.Println("Error scanning webhook row:", err)
+			return
+		}
+		webhooks = append(webhooks, hook)
+	}
+
+	writeJSON(w, http.StatusOK, webhooks)
+}
+
+func deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhookID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid webhook id", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Exec("DELETE FROM job_webhooks WHERE id = $1", webhookID)
+	if err != nil {
+		http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+		fmt.Println("Error deleting webhook:", err)
+		return
+	}
+
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	// Newest first, optionally filtered by status and job
+	query := r.URL.Query()
+
+	status := query.Get("status")
+	if status != "" && status != "pending" && status != "delivered" && status != "failed" {
+		http.Error(w, "Invalid status filter", http.StatusBadRequest)
+		return
+	}
+
+	var jobID *int
+	if value := query.Get("job_id"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid job_id", http.StatusBadRequest)
+			return
+		}
+		jobID = &parsed
+	}
+
+	limit := 50
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, 500)
+	}
+
+	rows, err := db.Query(`
+		SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE ($1 = '' OR status = $1) AND ($2::int IS NULL OR job_id = $2)
+		ORDER BY id DESC
+		LIMIT $3
+	`, status, jobID, limit)
+
+	if err != nil {
+		http.Error(w, "Failed to list webhook deliveries", http.StatusInternalServerError)
+		fmt.Println("Error listing webhook deliveries:", err)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			http.Error(w, "Failed to list webhook deliveries", http.StatusInternalServerError)
+			fmt.Println("Error scanning webhook delivery row:", err)
+			return
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+func retryWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	deliveryID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid webhook delivery id", http.StatusBadRequest)
+		return
+	}
+
+	// Give a delivery that ran out of attempts a fresh set of attempts
+	delivery, err := scanWebhookDelivery(db.QueryRow(`
+		UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+		WHERE id = $1 AND status = 'failed'
+		RETURNING `+webhookDeliveryColumns,
+		deliveryID,
+	))
+
+	if err == sql.ErrNoRows {
+		http.Error(w, "No failed webhook delivery with this id", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to retry webhook delivery", http.StatusInternalServerError)
+		fmt.Println("Error retrying webhook delivery:", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, delivery)
+}
+
+func listDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	// Newest first, filtered by name, reason, replayed and failed_before, paged by cursor
+	query := r.URL.Query()
+
+	where, args, err := deadLetterFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		cursorID, err := strconv.Atoi(cursor)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		args = append(args, cursorID)
+		if where == "" {
+			where = " WHERE"
+		} else {
+			where += " AND"
+		}
+		where += fmt.Sprintf(" id < $%d", len(args))
+	}
+
+	limit := 50
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, 500)
+	}
+
+	// Fetch one extra row to know whether another page exists
+	args = append(args, limit+1)
+	rows, err := db.Query(
+		"SELECT "+deadLetterColumns+" FROM dead_letters"+where+fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args)),
+		args...,
+	)
+
+	if err != nil {
+		http.Error(w, "Failed to list dead letters", http.StatusInternalServerError)
+		fmt.Println("Error listing dead letters:", err)
+		return
+	}
+	defer rows.Close()
+
+	deadLetters := []DeadLetter{}
+	for rows.Next() {
+		deadLetter, err := scanDeadLetter(rows)
+		if err != nil {
+			http.Error(w, "Failed to list dead letters", http.StatusInternalServerError)
+			fmt.Println("Error scanning dead letter row:", err)
+			return
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	var nextCursor *int
+	if len(deadLetters) > limit {
+		deadLetters = deadLetters[:limit]
+		nextCursor = &deadLetters[limit-1].ID
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"dead_letters": deadLetters,
+		"next_cursor":  nextCursor,
+	})
+}
+
+func getDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	deadLetterID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid dead letter id", http.StatusBadRequest)
+		return
+	}
+
+	deadLetter, err := scanDeadLetter(db.QueryRow(
+		"SELECT "+deadLetterColumns+" FROM dead_letters WHERE id = $1", deadLetterID,
+	))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Dead letter not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to fetch dead letter", http.StatusInternalServerError)
+		fmt.Println("Error fetching dead letter:", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deadLetter)
+}
+
+func replayDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	deadLetterID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid dead letter id", http.StatusBadRequest)
+		return
+	}
+
+	job, err := replayDeadLetter(deadLetterID)
+	if err == errNotReplayable {
+		http.Error(w, "Dead letter not found, already replayed or its job is no longer failed", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to replay dead letter", http.StatusInternalServerError)
+		fmt.Println("Error replaying dead letter", deadLetterID, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"dead_letter_id": deadLetterID,
+		"job_id":         job.ID,
+	})
+}
+
+func replayDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	// Replay the dead letters listed in the body, or every dead letter that has not been
+	// replayed yet and matches the query filters
+	var request struct {
+		IDs []int `json:"ids"`
+	}
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ids := request.IDs
+	if len(ids) == 0 {
+		query := r.URL.Query()
+		where, args, err := deadLetterFilter(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if where == "" && query.Get("all") != "true" {
+			http.Error(w, "Pass ids, a filter or all=true", http.StatusBadRequest)
+			return
+		}
+
+		if where == "" {
+			where = " WHERE replayed_at IS NULL"
+		} else {
+			where += " AND replayed_at IS NULL"
+		}
+
+		rows, err := db.Query("SELECT id FROM dead_letters"+where+" ORDER BY id LIMIT 1000", args...)
+		if err != nil {
+			http.Error(w, "Failed to replay dead letters", http.StatusInternalServerError)
+			fmt.Println("Error selecting dead letters to replay:", err)
+			return
+		}
+
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+	}
+
+	replayed := []int{}
+	skipped := []int{}
+	for _, id := range ids {
+		if _, err := replayDeadLetter(id); err != nil {
+			if err != errNotReplayable {
+				fmt.Println("Error replaying dead letter", id, err)
+			}
+			skipped = append(skipped, id)
+			continue
+		}
+		replayed = append(replayed, id)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"replayed": replayed,
+		"skipped":  skipped,
+	})
+}
+
+func deleteDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	deadLetterID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid dead letter id", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Exec("DELETE FROM dead_letters WHERE id = $1", deadLetterID)
+	if err != nil {
+		http.Error(w, "Failed to delete dead letter", http.StatusInternalServerError)
+		fmt.Println("Error deleting dead letter:", err)
+		return
+	}
+
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func purgeDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	// Delete every dead letter matching the query filters, all=true is required to
+	// purge without a filter
+	query := r.URL.Query()
+
+	where, args, err := deadLetterFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if where == "" && query.Get("all") != "true" {
+		http.Error(w, "Pass a filter or all=true", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Exec("DELETE FROM dead_letters"+where, args...)
+	if err != nil {
+		http.Error(w, "Failed to purge dead letters", http.StatusInternalServerError)
+		fmt.Println("Error purging dead letters:", err)
+		return
+	}
+
+	purged, _ := res.RowsAffected()
+	fmt.Println("Purged", purged, "dead letters")
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"purged": purged,
+	})
+}
+
+func listEscalationRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query("SELECT id, job_name, sink, target, group_window_seconds FROM escalation_rules ORDER BY id")
+	if err != nil {
+		http.Error(w, "Failed to list escalation rules", http.StatusInternalServerError)
+		fmt.Println("Error listing escalation rules:", err)
+		return
+	}
+	defer rows.Close()
+
+	rules := []EscalationRule{}
+	for rows.Next() {
+		var rule EscalationRule
+		if err := rows.Scan(&rule.ID, &rule.JobName, &rule.Sink, &rule.Target, &rule.GroupWindowSeconds); err != nil {
+			http.Error(w, "Failed to list escalation rules", http.StatusInternalServerError)
+			fmt.Println("Error scanning escalation rule row:", err)
+			return
+		}
+		rules = append(rules, rule)
+	}
+
+	writeJSON(w, http.StatusOK, rules)
+}
+
+func createEscalationRuleHandler(w http.ResponseWriter, r *http.Request) {
+	rule := EscalationRule{GroupWindowSeconds: DEFAULT_GROUP_WINDOW_SECONDS}
+
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateEscalationRule(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := db.QueryRow(
+		"INSERT INTO escalation_rules (job_name, sink, target, group_window_seconds) VALUES ($1, $2, $3, $4) RETURNING id",
+		rule.JobName, rule.Sink, rule.Target, rule.GroupWindowSeconds,
+	).Scan(&rule.ID)
+
+	if err != nil {
+		http.Error(w, "Failed to create escalation rule", http.StatusInternalServerError)
+		fmt.Println("Error creating escalation rule:", err)
+		return
+	}
+
+	fmt.Println("Created escalation rule", rule.ID, "to", rule.Sink, rule.Target)
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+func deleteEscalationRuleHandler(w http.ResponseWriter, r *http.Request) {
+	ruleID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid escalation rule id", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Exec("DELETE FROM escalation_rules WHERE id = $1", ruleID)
+	if err != nil {
+		http.Error(w, "Failed to delete escalation rule", http.StatusInternalServerError)
+		fmt.Println("Error deleting escalation rule:", err)
+		return
+	}
+
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		http.Error(w, "Escalation rule not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func loadSchedule(w http.ResponseWriter, r *http.Request) (Schedule, bool) {
+	scheduleID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid schedule id", http.StatusBadRequest)
+		return Schedule{}, false
+	}
+
+	schedule, err := scanSchedule(db.QueryRow("SELECT "+scheduleColumns+" FROM schedules WHERE id = $1", scheduleID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Schedule not found", http.StatusNotFound)
+			return Schedule{}, false
+		}
+		http.Error(w, "Failed to fetch schedule", http.StatusInternalServerError)
+		fmt.Println("Error fetching schedule", scheduleID, err)
+		return Schedule{}, false
+	}
+
+	return schedule, true
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		fmt.Println("Error encoding response:", err)
+	}
+}
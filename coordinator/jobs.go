@@ -3,21 +3,27 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/go-redis/redis"
 )
 
+// Returned when a popped job is no longer pending or is waiting for its next_attempt_at,
+// e.g. cancelled or a duplicate queue entry
+var errJobNotPending = errors.New("job is not pending")
+
 func distributeJobs() {
+	scheduler := newLaneScheduler()
+
 	for {
-		// BRPop (Blocking Right Pop), 0 -> wait forever until a new item is available
-		result, err := redisClient.BRPop(0, "job_queue").Result()
+		// BRPop (Blocking Right Pop) across the priority lanes, 0 -> wait forever until a new item is available
+		jobJson, err := scheduler.pop(0)
 		if err != nil {
 			fmt.Println("Error: ", err)
 			continue
 		}
-		jobJson := result[1]
 		fmt.Println("Received job:", jobJson)
 
 		// Parse the job
@@ -28,25 +34,39 @@ func distributeJobs() {
 		}
 
 		// Select a worker from available workers (LRU Logic)
-		workerUrl, err := selectWorkerAndLeaseJob(job)
-		if workerUrl == "" || err != nil {
+		lease, err := selectWorkerAndLeaseJob(&job)
+		if err == errJobNotPending {
+			fmt.Println("Job", job.ID, "is no longer pending, skipping")
+			continue
+		}
+		var deferred *jobDeferredError
+		if errors.As(err, &deferred) {
+			// Park the job in the scheduled set instead of pushing it back onto its lane,
+			// so the dispatcher keeps serving other jobs while this one has to wait
+			fmt.Println("Deferring job", job.ID, deferred)
+			if err := deferJob(job.ID, deferred.Delay); err != nil {
+				fmt.Println("Error deferring job", job.ID, err)
+			}
+			continue
+		}
+		if lease.WorkerUrl == "" || err != nil {
 			fmt.Println("No available worker found, requeueing job after delay")
 			time.Sleep(5 * time.Second)
-			redisClient.LPush("job_queue", jobJson)
+			enqueueJob(job)
 			continue
 		}
 
-		go sendJobToWorker(workerUrl, job)
+		go sendJobToWorker(lease, job)
 
 	}
 }
 
-func selectWorkerAndLeaseJob(job Job) (string, error) {
+func selectWorkerAndLeaseJob(job *Job) (Lease, error) {
 	tx, err := db.Begin()
 
 	if err != nil {
 		fmt.Println("Error starting transaction: ", err)
-		return "", err
+		return Lease{}, err
 	}
 
 	defer tx.Rollback()
@@ -65,22 +85,91 @@ func selectWorkerAndLeaseJob(job Job) (string, error) {
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return "", nil // no available workers found
+			return Lease{}, nil // no available workers found
 		}
 		fmt.Println("Error selecting workers", err)
-		return "", err
+		return Lease{}, err
 	}
 
-	// Update job status to leased and update leasing information
-	_, err = tx.Exec(`
-		UPDATE jobs
-		SET status = $1, lease_start = NOW(), lease_timeout = $2, leased_to_worker = $3
-		WHERE id = $4
-		`, "leased", 20, workerUrl, job.ID)
+	// Jobs sharing a concurrency key are serialized on an advisory lock, so two
+	// coordinators cannot both see a free slot and lease past the limit
+	var concurrencyKey sql.NullString
+	var concurrencyLimit int
+	var startSlot sql.NullTime
+	err = tx.QueryRow(
+		"SELECT concurrency_key, concurrency_limit, start_slot_at FROM jobs WHERE id = $1 AND status = 'pending' AND next_attempt_at IS NULL", job.ID,
+	).Scan(&concurrencyKey, &concurrencyLimit, &startSlot)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Lease{}, errJobNotPending
+		}
+		fmt.Println("Error reading job:", job.ID, err)
+		return Lease{}, err
+	}
+
+	if concurrencyKey.Valid {
+		var leased int
+		_, err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext('concurrency:' || $1))", concurrencyKey.String)
+		if err == nil {
+			err = tx.QueryRow(
+				"SELECT COUNT(*) FROM jobs WHERE concurrency_key = $1 AND status = 'leased'", concurrencyKey.String,
+			).Scan(&leased)
+		}
+		if err != nil {
+			fmt.Println("Error checking concurrency key:", concurrencyKey.String, err)
+			return Lease{}, err
+		}
+		if leased >= concurrencyLimit {
+			return Lease{}, &jobDeferredError{
+				Reason: fmt.Sprintf("concurrency key %s is at its limit of %d", concurrencyKey.String, concurrencyLimit),
+				Delay:  CONCURRENCY_DEFER_DELAY,
+			}
+		}
+	}
+
+	// Per name concurrency cap and start rate from the job_limits table
+	if err := checkJobLimit(tx, job.ID, job.Name, startSlot); err != nil {
+		if _, ok := err.(*jobDeferredError); !ok {
+			fmt.Println("Error checking job limit:", job.Name, err)
+			return Lease{}, err
+		}
+		// Keep the start slot reserved for the deferred job
+		if commitErr := tx.Commit(); commitErr != nil {
+			fmt.Println("Error committing transaction:", commitErr)
+			return Lease{}, commitErr
+		}
+		return Lease{}, err
+	}
+
+	// Update job status to leased and update leasing information, the lease length comes
+	// from the job's own lease_timeout_seconds. Only pending jobs without a next_attempt_at
+	// are leased, so cancelled jobs, stale queue entries of jobs waiting out a retry or
+	// deferral and duplicate queue entries are skipped. The payload is read back as it may
+	// have been replaced since the job was queued. Every lease takes the next lease_token,
+	// results and renewals carrying an older token come from an abandoned attempt
+	var leaseTimeout, leaseToken int
+	err = transitionJob(tx, JobTransition{
+		From:   []string{"pending"},
+		To:     "leased",
+		Reason: "leased",
+		Set:    "lease_start = NOW(), lease_renewed_at = NULL, lease_timeout = lease_timeout_seconds, leased_to_worker = $5, lease_token = lease_token + 1, next_attempt_at = NULL, start_slot_at = NULL",
+		Where:  "id = $4 AND next_attempt_at IS NULL",
+		Args:   []interface{}{job.ID, workerUrl},
+	}, "lease_timeout, lease_token, payload", &leaseTimeout, &leaseToken, &job.Payload)
 
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return Lease{}, errJobNotPending
+		}
 		fmt.Println("Error leasing job:", job.ID, err)
-		return "", err
+		return Lease{}, err
+	}
+
+	// Open the attempt in the same transaction, so every lease has its history row
+	if err := startAttempt(tx, job.ID, leaseToken, workerUrl); err != nil {
+		fmt.Println("Error recording attempt for job:", job.ID, err)
+		return Lease{}, err
 	}
 
 	// Update worker status as busy
@@ -91,17 +180,18 @@ func selectWorkerAndLeaseJob(job Job) (string, error) {
 
 	if err != nil {
 		fmt.Println("Error marking worker as busy:", workerUrl, err)
-		return "", err
+		return Lease{}, err
 	}
 
 	// Commit the transaction
 	if err = tx.Commit(); err != nil {
 		fmt.Println("Error committing transaction:", err)
-		return "", err
+		return Lease{}, err
 	}
 
-	fmt.Println("Leased job: ", job.ID, "to worker: ", workerUrl)
-	return workerUrl, nil
+	fmt.Println("Leased job: ", job.ID, "to worker: ", workerUrl, "for", leaseTimeout, "seconds with token", leaseToken)
+	publishJobEvent("leased", job.ID, job.Name, workerUrl)
+	return Lease{WorkerUrl: workerUrl, TimeoutSeconds: leaseTimeout, Token: leaseToken}, nil
 }
 
 func processJobResults() {
@@ -130,10 +220,23 @@ func processJobResults() {
 		status := jobResult["status"].(string)
 		workerUrl := jobResult["worker_url"].(string)
 
+		// Fencing token of the lease the worker ran the job under, echoed back from /run_job
+		var resultToken int
+		if token, ok := jobResult["lease_token"].(float64); ok {
+			resultToken = int(token)
+		}
+
+		// Why the attempt failed, kept on its job_attempts row
+		errorMessage, _ := jobResult["error"].(string)
+
 		// Check if job is already marked completed by some other worker then ignore the result push
-		var dbJobStatus string
-		var currentRetries int
-		err = db.QueryRow("SELECT status, retries FROM jobs WHERE id = $1", jobID).Scan(&dbJobStatus, &currentRetries)
+		var dbJobStatus, jobName string
+		var currentRetries, leaseToken int
+		var policy RetryPolicy
+		err = db.QueryRow(
+			"SELECT name, status, retries, lease_token, max_retries, backoff_policy, backoff_base_seconds, backoff_max_seconds FROM jobs WHERE id = $1",
+			jobID,
+		).Scan(&jobName, &dbJobStatus, &currentRetries, &leaseToken, &policy.MaxRetries, &policy.Backoff, &policy.BaseSeconds, &policy.MaxSeconds)
 
 		if err != nil {
 			if err == sql.ErrNoRows {
@@ -149,6 +252,23 @@ func processJobResults() {
 			continue
 		}
 
+		if dbJobStatus == "cancelled" {
+			// Ignore the result but release the worker which has stopped working on it
+			fmt.Println("Job", jobID, "was cancelled, ignoring result push by ", workerUrl)
+			finishAttempt(jobID, resultToken, "cancelled", errorMessage)
+			updateWorkerState(workerUrl, "available")
+			continue
+		}
+
+		if dbJobStatus != "leased" || resultToken != leaseToken {
+			// The lease this result was produced under expired and the job was retried or
+			// failed since, only the current attempt may decide the job's outcome
+			fmt.Println("Job", jobID, "result from", workerUrl, "has stale lease token", resultToken, "current token", leaseToken, "status", dbJobStatus, "ignoring")
+			staleResults.Inc()
+			updateWorkerState(workerUrl, "available")
+			continue
+		}
+
 		if status == "completed" {
 			// Record job completion
 			jobsTotal.WithLabelValues("completed").Inc()
@@ -162,47 +282,84 @@ func processJobResults() {
 			}
 
 			result := jobResult["result"].(string)
-			_, err = db.Exec(
-				"UPDATE jobs SET status = $1, completed_at = NOW(), result = $2 WHERE id = $3",
-				status, result, jobID,
-			)
+			err := transitionJob(db, JobTransition{
+				From:   []string{"leased"},
+				To:     "completed",
+				Reason: "completed",
+				Set:    "completed_at = NOW(), result = $6",
+				Where:  "id = $4 AND lease_token = $5",
+				Args:   []interface{}{jobID, leaseToken, result},
+			}, "")
+
+			if err == sql.ErrNoRows {
+				// The lease expired or the job was cancelled since it was read above
+				fmt.Println("Job", jobID, "lease token", leaseToken, "is no longer current, ignoring result push by", workerUrl)
+				staleResults.Inc()
+				updateWorkerState(workerUrl, "available")
+				continue
+			}
 
 			if err != nil {
-				fmt.Println("Error updating job_id:", jobID, "results in database")
+				fmt.Println("Error updating job_id:", jobID, "results in database", err)
 				continue
 			}
 
 			fmt.Println("Completed job_id", jobID, "and updated results in database")
+			finishAttempt(jobID, leaseToken, "completed", "")
+			publishJobEvent("completed", jobID, jobName, workerUrl)
+
+			jobFinished(jobID)
 
 		} else {
-			// Job Failed
-			if currentRetries >= MAX_RETRIES {
-				// Record failed job
-				jobsTotal.WithLabelValues("failed").Inc()
-				retryAttempts.WithLabelValues("max_retries_exceeded").Observe(float64(currentRetries))
+			// Job Failed, the attempt is only recorded once the job has moved, so an
+			// attempt that lost the race to the lease monitor keeps its outcome
+			if currentRetries >= policy.MaxRetries {
+				// Fail the job before dead lettering it, so a job whose lease expired or
+				// that was cancelled in the meantime is not dead lettered as well
+				err := transitionJob(db, JobTransition{
+					From:   []string{"leased"},
+					To:     "failed",
+					Reason: "max_retries_exceeded",
+					Set:    "completed_at = NOW()",
+					Where:  "id = $4 AND lease_token = $5",
+					Args:   []interface{}{jobID, leaseToken},
+				}, "")
+
+				if err == sql.ErrNoRows {
+					fmt.Println("Job", jobID, "lease token", leaseToken, "is no longer current, not failing it")
+				} else if err != nil {
+					fmt.Println("Error marking job as failed for job:", jobID, err)
+				} else {
+					finishAttempt(jobID, leaseToken, "failed", errorMessage)
 
-				sendToDeadLetterQueue(jobID, jobResult)
+					// Record failed job
+					jobsTotal.WithLabelValues("failed").Inc()
+					retryAttempts.WithLabelValues("max_retries_exceeded").Observe(float64(currentRetries))
 
-				_, err := db.Exec(
-					"UPDATE jobs SET status = 'failed', completed_at = NOW() where id = $1", jobID,
-				)
+					sendToDeadLetterQueue(jobID, jobResult, "max_retries_exceeded")
+					publishJobEvent("dead_lettered", jobID, jobName, workerUrl)
 
-				if err != nil {
-					fmt.Println("Error marking job as failed for job:", jobID, err)
+					fmt.Println("Job:", jobID, "exceeded max retries, sent to DLQ")
+					publishJobEvent("failed", jobID, jobName, workerUrl)
+
+					jobFinished(jobID)
 				}
-				fmt.Println("Job:", jobID, "exceeded max retries, sent to DLQ")
 			} else {
 				// Record retry attempt
 				retryAttempts.WithLabelValues("worker_failure").Observe(float64(currentRetries))
 
-				// Retry the job with exponential backoff
-				delay := calculateBackoffDelay(currentRetries)
-				fmt.Printf("Job %s failed, retrying in %v (attempt %d/%d)\n", jobID, delay, currentRetries+1, MAX_RETRIES)
+				// Retry the job with the job's backoff policy
+				delay := calculateBackoffDelay(policy, currentRetries)
+				fmt.Printf("Job %s failed, retrying in %v (attempt %d/%d)\n", jobID, delay, currentRetries+1, policy.MaxRetries)
 
-				go func(id string, retries int, delay time.Duration) {
-					time.Sleep(delay)
-					requeueFailedJob(id)
-				}(jobID, currentRetries, delay)
+				retried, err := scheduleRetry(jobID, leaseToken, delay)
+				if err != nil {
+					fmt.Println("Error scheduling retry for job:", jobID, err)
+				}
+				if retried {
+					finishAttempt(jobID, leaseToken, "failed", errorMessage)
+					publishJobEvent("retried", jobID, jobName, workerUrl)
+				}
 			}
 		}
 
@@ -212,44 +369,62 @@ func processJobResults() {
 	}
 }
 
-func requeueFailedJob(jobID string) {
-	// Get job details from database
-	var job Job
-	err := db.QueryRow("SELECT id, name, payload FROM jobs WHERE id = $1", jobID).Scan(&job.ID, &job.Name, &job.Payload)
-	if err != nil {
-		fmt.Printf("Error fetching job %s for requeue: %v\n", jobID, err)
+func jobFinished(jobID string) {
+	// Follow up on a job that reached a terminal state: notify its webhooks, start
+	// workflow jobs that were waiting on it and finalize its batch if it was the last member
+	queueJobWebhooks(jobID)
+	advanceWorkflowsAfter(jobID)
+	finalizeBatchAfter(jobID)
+}
+
+func requeueLeasedJob(job Job, leaseToken int) {
+	// Return a job that never reached its worker to pending and put it back in its lane
+	err := transitionJob(db, JobTransition{
+		From:   []string{"leased"},
+		To:     "pending",
+		Reason: "requeued",
+		Set:    "lease_start = NULL, lease_renewed_at = NULL, lease_timeout = NULL",
+		Where:  "id = $4 AND lease_token = $5",
+		Args:   []interface{}{job.ID, leaseToken},
+	}, "")
+
+	if err == sql.ErrNoRows {
+		// Cancelled or already finished in the meantime
 		return
 	}
-
-	// Update job status to pending and increment retry count
-	_, err = db.Exec(
-		"UPDATE jobs SET status = 'pending', lease_start = NULL, lease_timeout = NULL, retries = retries + 1 WHERE id = $1",
-		jobID,
-	)
 	if err != nil {
-		fmt.Printf("Error updating job %s for requeue: %v\n", jobID, err)
+		fmt.Println("Error resetting lease for job:", job.ID, err)
 		return
 	}
 
-	// Add back to job queue
-	jobJson, _ := json.Marshal(job)
-	redisClient.LPush("job_queue", jobJson)
-	fmt.Printf("Requeued failed job %s\n", jobID)
+	finishAttempt(job.ID, leaseToken, "requeued", "")
+
+	if err := enqueueJob(job); err != nil {
+		fmt.Println("Error requeueing job:", job.ID, err)
+	}
 }
 
-func sendToDeadLetterQueue(jobID string, jobResult map[string]interface{}) {
+func sendToDeadLetterQueue(jobID string, jobResult map[string]interface{}, reason string) {
+	// Store the dead letter in Postgres first, it is what the /dead_letters endpoints
+	// list and replay. The Redis queue only notifies processDLQ
+	deadLetterID, err := recordDeadLetter(jobID, jobResult, reason)
+	if err != nil {
+		fmt.Printf("Error storing dead letter for job %s: %v\n", jobID, err)
+	}
+
 	// Add metadata to the DQL message
 	dqlMessage := map[string]interface{}{
-		"job_id":       jobID,
-		"original_job": jobResult,
-		"failed_at":    time.Now().UTC(),
-		"reason":       "max_retries_exceeded",
+		"dead_letter_id": deadLetterID,
+		"job_id":         jobID,
+		"original_job":   jobResult,
+		"failed_at":      time.Now().UTC(),
+		"reason":         reason,
 	}
 
 	dqlJson, _ := json.Marshal(dqlMessage)
 
 	// Push to dead  letter queue in Redis
-	err := redisClient.LPush(DLQ_QUEUE, dqlJson).Err()
+	err = redisClient.LPush(DLQ_QUEUE, dqlJson).Err()
 
 	if err != nil {
 		fmt.Printf("Error sending job %s to DQL: %v\n", jobID, err)
@@ -272,18 +447,54 @@ func processDLQ() {
 			continue
 		}
 
+		// The dead letter itself is already stored in the dead_letters table
 		dqlMessage := result[1]
 		fmt.Println("DQL Message:", dqlMessage)
-		// Placeholder to add further functionality to escalate the dead job
+
+		// Alert through the escalation rules matching the job
+		escalateDeadLetter(dqlMessage)
+	}
+}
+
+func cancelJob(jobID string) (string, error) {
+	// Cancel a job that has not reached a terminal state and return its previous status,
+	// sql.ErrNoRows means there was nothing to cancel
+	var previousStatus, name string
+	var leasedToWorker sql.NullString
+
+	err := transitionJob(db, JobTransition{
+		From:   []string{"pending", "scheduled", "queued", "blocked", "leased"},
+		To:     "cancelled",
+		Reason: "cancelled",
+		Set:    "completed_at = NOW()",
+		Where:  "id = $4",
+		Args:   []interface{}{jobID},
+	}, "previous_status, leased_to_worker, name", &previousStatus, &leasedToWorker, &name)
+
+	if err != nil {
+		return "", err
+	}
+
+	jobsTotal.WithLabelValues("cancelled").Inc()
+	publishJobEvent("cancelled", jobID, name, leasedToWorker.String)
+
+	// Pending, scheduled and queued jobs are skipped when popped, released or promoted,
+	// a leased job is stopped on its worker and its result is ignored when it arrives
+	if previousStatus == "leased" && leasedToWorker.Valid {
+		go cancelJobOnWorker(leasedToWorker.String, jobID)
 	}
+
+	jobFinished(jobID)
+	return previousStatus, nil
 }
 
 func leaseMonitor() {
 	for {
+		// Leases renewed by their worker run from the last renewal
 		rows, err := db.Query(`
-			SELECT id, name, payload, retries FROM jobs
+			SELECT id, name, payload, priority, retries, lease_token, max_retries, backoff_policy, backoff_base_seconds, backoff_max_seconds FROM jobs
 			WHERE status = 'leased'
-			AND lease_start + (lease_timeout || ' seconds')::interval < NOW()
+			AND ` + leaseExpiresAt + ` < NOW()
 		`)
 
 		if err != nil {
@@ -294,15 +505,22 @@ func leaseMonitor() {
 
 		var expiredJobs []struct {
 			Job
-			Retries int
+			Retries    int
+			LeaseToken int
+			Policy     RetryPolicy
 		}
 
 		for rows.Next() {
 			var job struct {
 				Job
-				Retries int
+				Retries    int
+				LeaseToken int
+				Policy     RetryPolicy
 			}
-			if err := rows.Scan(&job.ID, &job.Name, &job.Payload, &job.Retries); err != nil {
+			if err := rows.Scan(
+				&job.ID, &job.Name, &job.Payload, &job.Priority, &job.Retries, &job.LeaseToken,
+				&job.Policy.MaxRetries, &job.Policy.Backoff, &job.Policy.BaseSeconds, &job.Policy.MaxSeconds,
+			); err != nil {
 				fmt.Println("Error scanning job row:", err)
 				continue
 			}
@@ -312,10 +530,32 @@ func leaseMonitor() {
 		rows.Close()
 
 		for _, expiredJob := range expiredJobs {
-			// Record lease timeout
-			leaseTimeouts.Inc()
+			// The attempt is only recorded as timed out once the job has moved, the
+			// worker's result may still win the race
+			if expiredJob.Retries >= expiredJob.Policy.MaxRetries {
+				// Mark as failed first, the result may have arrived since the lease was read
+				err := transitionJob(db, JobTransition{
+					From:   []string{"leased"},
+					To:     "failed",
+					Reason: "lease_timeout",
+					Set:    "completed_at = NOW()",
+					Where:  "id = $4 AND lease_token = $5 AND " + leaseExpiresAt + " < NOW()",
+					Args:   []interface{}{expiredJob.ID, expiredJob.LeaseToken},
+				}, "")
+
+				if err == sql.ErrNoRows {
+					fmt.Println("Expired job", expiredJob.ID, "finished in the meantime, not failing it")
+					continue
+				}
+				if err != nil {
+					fmt.Println("Error marking expired job as failed: ", err)
+					continue
+				}
+
+				// Record lease timeout
+				leaseTimeouts.Inc()
+				finishAttempt(expiredJob.ID, expiredJob.LeaseToken, "timed_out", "Job lease expired")
 
-			if expiredJob.Retries >= MAX_RETRIES {
 				// Record timeout job sent to DLQ
 				jobsTotal.WithLabelValues("timeout").Inc()
 				retryAttempts.WithLabelValues("lease_timeout").Observe(float64(expiredJob.Retries))
@@ -329,45 +569,31 @@ func leaseMonitor() {
 					"error":      "Job lease expired - max retries exceeded",
 					"worker_url": "",
 				}
-				sendToDeadLetterQueue(expiredJob.ID, jobResult)
-
-				// Mark as failed
-				_, err := db.Exec(
-					"UPDATE jobs SET status = 'failed', completed_at = NOW() WHERE id = $1",
-					expiredJob.ID,
-				)
-
-				if err != nil {
-					fmt.Println("Error marking expired job as failed: ", err)
-				}
+				sendToDeadLetterQueue(expiredJob.ID, jobResult, "lease_timeout")
+				publishJobEvent("dead_lettered", expiredJob.ID, expiredJob.Name, "")
 
 				fmt.Printf("Expired job %s sent to DLQ after %d retries\n", expiredJob.ID, expiredJob.Retries)
+				publishJobEvent("failed", expiredJob.ID, expiredJob.Name, "")
+
+				jobFinished(expiredJob.ID)
 			} else {
 				// Record retry for timeout
 				retryAttempts.WithLabelValues("lease_timeout").Observe(float64(expiredJob.Retries))
 
-				// Retry the job with exponential backoff
-				delay := calculateBackoffDelay(expiredJob.Retries)
-				fmt.Printf("Job %s lease expired, retrying in %v (attempt %d/%d)\n", expiredJob.ID, delay, expiredJob.Retries+1, MAX_RETRIES)
+				// Retry the job with the job's backoff policy
+				delay := calculateBackoffDelay(expiredJob.Policy, expiredJob.Retries)
+				fmt.Printf("Job %s lease expired, retrying in %v (attempt %d/%d)\n", expiredJob.ID, delay, expiredJob.Retries+1, expiredJob.Policy.MaxRetries)
 
-				// Update job status
-				_, err := db.Exec(
-					"UPDATE jobs SET status = 'pending', lease_start = NULL, lease_timeout = NULL, retries = retries + 1 WHERE id = $1",
-					expiredJob.ID,
-				)
+				retried, err := scheduleRetry(expiredJob.ID, expiredJob.LeaseToken, delay)
 				if err != nil {
-					fmt.Println("Unable to update lease timeout job to database, job_id:", expiredJob.ID, err)
-					continue
+					fmt.Println("Unable to schedule retry for lease timeout job, job_id:", expiredJob.ID, err)
+				}
+				if retried {
+					// Record lease timeout
+					leaseTimeouts.Inc()
+					finishAttempt(expiredJob.ID, expiredJob.LeaseToken, "timed_out", "Job lease expired")
+					publishJobEvent("retried", expiredJob.ID, expiredJob.Name, "")
 				}
-
-				// Schedule requeue with delay
-				go func(job Job, delay time.Duration) {
-					time.Sleep(delay)
-					jobJson, _ := json.Marshal(job)
-					redisClient.LPush("job_queue", jobJson)
-					fmt.Printf("Requeued expired job %s after backoff\n", job.ID)
-				}(expiredJob.Job, delay)
-
 			}
 
 		}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// A lease runs for lease_timeout seconds from when it was taken or last renewed. Workers
+// renew it while the job is still making progress, so long jobs can use short leases and
+// a crashed worker is still noticed within one lease_timeout
+const leaseExpiresAt = "COALESCE(lease_renewed_at, lease_start) + (lease_timeout || ' seconds')::interval"
+
+// Returned when the job is not leased to the worker renewing it under the given token, or
+// its lease already ran out
+var errLeaseNotHeld = errors.New("lease is not held by this worker")
+
+func renewLease(jobID string, workerUrl string, leaseToken int) (time.Time, error) {
+	// An expired lease is not renewed, it belongs to the lease monitor which retries the job
+	var expiresAt time.Time
+	err := db.QueryRow(`
+		UPDATE jobs SET lease_renewed_at = NOW()
+		WHERE id = $1 AND status = 'leased' AND leased_to_worker = $2 AND lease_token = $3 AND `+leaseExpiresAt+` > NOW()
+		RETURNING `+leaseExpiresAt,
+		jobID, workerUrl, leaseToken,
+	).Scan(&expiresAt)
+
+	if err == sql.ErrNoRows {
+		return time.Time{}, errLeaseNotHeld
+	}
+	return expiresAt, err
+}
+
+func leaseHeld(jobID string, leaseToken int) bool {
+	// Whether the lease with this token is still current and unexpired
+	var held bool
+	err := db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM jobs WHERE id = $1 AND status = 'leased' AND lease_token = $2 AND "+leaseExpiresAt+" > NOW())",
+		jobID, leaseToken,
+	).Scan(&held)
+
+	return err == nil && held
+}
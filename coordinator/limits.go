@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// JobLimit caps how many jobs of one name run at once and how fast they start,
+// a nil field means that limit is not enforced
+type JobLimit struct {
+	Name               string   `json:"name"`
+	MaxConcurrent      *int     `json:"max_concurrent"`
+	MaxStartsPerSecond *float64 `json:"max_starts_per_second"`
+}
+
+// How long a job waits before trying again when its name is at the concurrency cap
+const JOB_LIMIT_DEFER_DELAY = 2 * time.Second
+
+// jobDeferredError is returned when a job may not be leased yet, the dispatcher parks
+// it in the scheduled set for Delay instead of pushing it back onto its lane
+type jobDeferredError struct {
+	Reason string
+	Delay  time.Duration
+}
+
+func (e *jobDeferredError) Error() string {
+	return fmt.Sprintf("job deferred for %s: %s", e.Delay, e.Reason)
+}
+
+func checkJobLimit(tx *sql.Tx, jobID string, name string, startSlot sql.NullTime) error {
+	// Locking the limit row serializes leases of the same name across coordinators,
+	// so the count and the start slot below cannot be claimed twice. startSlot is the
+	// slot the job reserved when it was last deferred for the start rate
+	var maxConcurrent sql.NullInt64
+	var maxStartsPerSecond sql.NullFloat64
+	var nextStartAt sql.NullTime
+
+	err := tx.QueryRow(`
+		SELECT max_concurrent, max_starts_per_second, next_start_at FROM job_limits
+		WHERE name = $1
+		FOR UPDATE
+	`, name).Scan(&maxConcurrent, &maxStartsPerSecond, &nextStartAt)
+
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if maxConcurrent.Valid {
+		var leased int64
+		err = tx.QueryRow("SELECT COUNT(*) FROM jobs WHERE name = $1 AND status = 'leased'", name).Scan(&leased)
+		if err != nil {
+			return err
+		}
+		if leased >= maxConcurrent.Int64 {
+			return &jobDeferredError{
+				Reason: fmt.Sprintf("%d %s jobs already running", leased, name),
+				Delay:  JOB_LIMIT_DEFER_DELAY,
+			}
+		}
+	}
+
+	if maxStartsPerSecond.Valid && maxStartsPerSecond.Float64 > 0 {
+		now := time.Now().UTC()
+		reason := fmt.Sprintf("%s is limited to %g starts per second", name, maxStartsPerSecond.Float64)
+
+		// A job holding a slot starts once the slot is reached, it is not counted again
+		if startSlot.Valid {
+			if startSlot.Time.After(now) {
+				return &jobDeferredError{Reason: reason, Delay: startSlot.Time.Sub(now)}
+			}
+			return nil
+		}
+
+		slot, next := reserveStartSlot(now, nextStartAt, maxStartsPerSecond.Float64)
+		_, err = tx.Exec("UPDATE job_limits SET next_start_at = $1 WHERE name = $2", next, name)
+		if err != nil {
+			return err
+		}
+
+		// Every deferred job keeps the slot it reserved, so a backlog is started at the
+		// configured rate instead of all of it waking up for the same slot
+		if slot.After(now) {
+			_, err = tx.Exec("UPDATE jobs SET start_slot_at = $1 WHERE id = $2", slot, jobID)
+			if err != nil {
+				return err
+			}
+			return &jobDeferredError{Reason: reason, Delay: slot.Sub(now)}
+		}
+	}
+
+	return nil
+}
+
+func reserveStartSlot(now time.Time, nextStartAt sql.NullTime, startsPerSecond float64) (time.Time, time.Time) {
+	// Starts are spaced evenly, the earliest free slot is taken and the next one is
+	// 1/rate seconds after it
+	slot := now
+	if nextStartAt.Valid && nextStartAt.Time.After(now) {
+		slot = nextStartAt.Time
+	}
+
+	interval := time.Duration(float64(time.Second) / startsPerSecond)
+	return slot, slot.Add(interval)
+}
+
+func validateJobLimit(limit *JobLimit) error {
+	if limit.MaxConcurrent != nil && *limit.MaxConcurrent <= 0 {
+		return fmt.Errorf("max_concurrent must be positive")
+	}
+	if limit.MaxStartsPerSecond != nil && *limit.MaxStartsPerSecond <= 0 {
+		return fmt.Errorf("max_starts_per_second must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestReserveStartSlot(t *testing.T) {
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		nextStartAt     sql.NullTime
+		startsPerSecond float64
+		wantSlot        time.Time
+		wantNext        time.Time
+	}{
+		{
+			name:            "no previous start",
+			startsPerSecond: 2,
+			wantSlot:        now,
+			wantNext:        now.Add(500 * time.Millisecond),
+		},
+		{
+			name:            "next slot already passed",
+			nextStartAt:     sql.NullTime{Time: now.Add(-time.Second), Valid: true},
+			startsPerSecond: 2,
+			wantSlot:        now,
+			wantNext:        now.Add(500 * time.Millisecond),
+		},
+		{
+			name:            "next slot in the future",
+			nextStartAt:     sql.NullTime{Time: now.Add(300 * time.Millisecond), Valid: true},
+			startsPerSecond: 10,
+			wantSlot:        now.Add(300 * time.Millisecond),
+			wantNext:        now.Add(400 * time.Millisecond),
+		},
+		{
+			name:            "less than one start per second",
+			startsPerSecond: 0.5,
+			wantSlot:        now,
+			wantNext:        now.Add(2 * time.Second),
+		},
+	}
+
+	for _, tt := range tests {
+		slot, next := reserveStartSlot(now, tt.nextStartAt, tt.startsPerSecond)
+		if !slot.Equal(tt.wantSlot) || !next.Equal(tt.wantNext) {
+			t.Errorf("%s: reserveStartSlot = %v, %v, want %v, %v", tt.name, slot, next, tt.wantSlot, tt.wantNext)
+		}
+	}
+}
+
+func TestReserveStartSlotBacklog(t *testing.T) {
+	// A backlog arriving at once is spread over consecutive slots, not started together
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	var nextStartAt sql.NullTime
+
+	for i := 0; i < 20; i++ {
+		slot, next := reserveStartSlot(now, nextStartAt, 10)
+		if want := now.Add(time.Duration(i) * 100 * time.Millisecond); !slot.Equal(want) {
+			t.Fatalf("job %d: slot = %v, want %v", i, slot, want)
+		}
+		nextStartAt = sql.NullTime{Time: next, Valid: true}
+	}
+}
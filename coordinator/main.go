@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/go-redis/redis"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -23,14 +24,30 @@ type Worker struct {
 }
 
 type Job struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Payload string `json:"payload"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Payload  string `json:"payload"`
+	Priority string `json:"priority"`
+}
+
+// RetryPolicy holds the per-job retry settings stored in the jobs table
+type RetryPolicy struct {
+	MaxRetries  int
+	Backoff     string // exponential, linear or fixed
+	BaseSeconds int
+	MaxSeconds  int
+}
+
+// Lease describes a job handed to a worker by selectWorkerAndLeaseJob
+type Lease struct {
+	WorkerUrl      string
+	TimeoutSeconds int
+	Token          int // fencing token, the job's lease_token for this lease
 }
 
 const (
-	MAX_RETRIES = 3
-	DLQ_QUEUE   = "dead_letter_queue"
+	DLQ_QUEUE       = "dead_letter_queue"
+	SCHEDULED_QUEUE = "scheduled_jobs"
 )
 
 func main() {
@@ -78,10 +95,62 @@ func main() {
 		panic("Could not connect to Redis: " + err.Error())
 	}
 
+	// Secret used to sign webhook requests, receivers cannot tell an unsigned request
+	// from a forged one so without it webhooks cannot be registered and none are sent
+	webhookSecret = os.Getenv("WEBHOOK_SECRET")
+
+	if webhookSecret == "" {
+		fmt.Println("WEBHOOK_SECRET not set, webhooks cannot be registered and deliveries are held")
+	}
+
 	// HTTP Handlers
 	go func() {
 		http.HandleFunc("/register_worker", registerWorkerHandler)
 
+		// Rebuild Redis state from Postgres on demand
+		http.HandleFunc("POST /admin/reconcile", reconcileHandler)
+
+		// Cancellations forwarded by the submitter
+		http.HandleFunc("POST /jobs/{id}/cancel", cancelJobHandler)
+
+		// Lease renewal heartbeats from workers running a job
+		http.HandleFunc("POST /leases/{job_id}/renew", renewLeaseHandler)
+
+		// Recurring schedules
+		http.HandleFunc("POST /schedules", createScheduleHandler)
+		http.HandleFunc("GET /schedules", listSchedulesHandler)
+		http.HandleFunc("GET /schedules/{id}", getScheduleHandler)
+		http.HandleFunc("PUT /schedules/{id}", updateScheduleHandler)
+		http.HandleFunc("DELETE /schedules/{id}", deleteScheduleHandler)
+
+		// Per job name limits
+		http.HandleFunc("GET /limits", listJobLimitsHandler)
+		http.HandleFunc("PUT /limits/{name}", putJobLimitHandler)
+		http.HandleFunc("DELETE /limits/{name}", deleteJobLimitHandler)
+
+		// Webhooks per job name and their delivery log
+		http.HandleFunc("POST /webhooks", createWebhookHandler)
+		http.HandleFunc("GET /webhooks", listWebhooksHandler)
+		http.HandleFunc("DELETE /webhooks/{id}", deleteWebhookHandler)
+		http.HandleFunc("GET /webhook_deliveries", listWebhookDeliveriesHandler)
+		http.HandleFunc("POST /webhook_deliveries/{id}/retry", retryWebhookDeliveryHandler)
+
+		// Dead letter store
+		http.HandleFunc("GET /dead_letters", listDeadLettersHandler)
+		http.HandleFunc("GET /dead_letters/{id}", getDeadLetterHandler)
+		http.HandleFunc("POST /dead_letters/{id}/replay", replayDeadLetterHandler)
+		http.HandleFunc("POST /dead_letters/replay", replayDeadLettersHandler)
+		http.HandleFunc("DELETE /dead_letters/{id}", deleteDeadLetterHandler)
+		http.HandleFunc("DELETE /dead_letters", purgeDeadLettersHandler)
+
+		// Dead letter escalation rules
+		http.HandleFunc("GET /escalation_rules", listEscalationRulesHandler)
+		http.HandleFunc("POST /escalation_rules", createEscalationRuleHandler)
+		http.HandleFunc("DELETE /escalation_rules/{id}", deleteEscalationRuleHandler)
+
+		// Live stream of job and worker events
+		http.HandleFunc("GET /events", eventsHandler)
+
 		// Prometheus metrics endpoint
 		http.Handle("/metrics", promhttp.Handler())
 
@@ -91,6 +160,10 @@ func main() {
 		log.Fatal(http.ListenAndServe(":9000", nil))
 	}()
 
+	// Repair Redis queues from Postgres before dispatching
+	reconcile("startup")
+	go reconcileLoop()
+
 	// Worker Heartbeat Verifier
 	go workerHeartbeatVerifier()
 
@@ -100,12 +173,33 @@ func main() {
 	// Lease Monitor
 	go leaseMonitor()
 
+	// Scheduled Job Releaser
+	go releaseScheduledJobs()
+
+	// Recurring Schedule Runner
+	go runSchedules()
+
+	// Queued Unique Job Promoter
+	go promoteQueuedJobs()
+
+	// Workflow Dependency Monitor
+	go workflowMonitor()
+
+	// Batch Finalizer
+	go batchMonitor()
+
+	// Webhook Dispatcher
+	go webhookDispatcher()
+
 	// Job Result Processor
 	go processJobResults()
 
 	// DLQ Processor
 	go processDLQ()
 
+	// DLQ Escalation Summaries
+	go flushEscalationGroups()
+
 	// Metrics Updater
 	go updateMetrics()
 
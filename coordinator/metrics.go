@@ -13,7 +13,7 @@ var (
 			Name: "jobs_total",
 			Help: "Total number of jobs procesed by status",
 		},
-		[]string{"status"}, // completed, failed, timeout
+		[]string{"status"}, // completed, failed, timeout, cancelled, upstream_failed
 	)
 
 	workersActive = promauto.NewGaugeVec(
@@ -31,6 +31,14 @@ var (
 		},
 	)
 
+	jobsInQueueByPriority = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "jobs_in_queue_by_priority",
+			Help: "Number of jobs waiting in each priority lane",
+		},
+		[]string{"priority"}, // high, normal, low
+	)
+
 	jobsInDLQ = promauto.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "jobs_in_dlq",
@@ -56,12 +64,34 @@ var (
 		[]string{"reason"}, // timeout, failure, worker_error
 	)
 
+	reconcileRepairs = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dts_reconcile_repairs_total",
+			Help: "Total number of repairs made by the reconciler",
+		},
+		[]string{"kind"}, // requeued_job, scheduled_restored, released_worker
+	)
+
 	leaseTimeouts = promauto.NewCounter(
 		prometheus.CounterOpts{
 			Name: "dts_lease_timeouts_total",
 			Help: "Total number of job lease timeouts",
 		},
 	)
+
+	staleResults = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "dts_stale_results_total",
+			Help: "Total number of worker results ignored for carrying a stale lease token",
+		},
+	)
+
+	leaseRenewals = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "dts_lease_renewals_total",
+			Help: "Total number of job leases renewed by workers",
+		},
+	)
 )
 
 func updateMetrics() {
@@ -83,8 +113,13 @@ func updateMetrics() {
 			rows.Close()
 		}
 
-		// Update jobs in queue
-		queueLength := redisClient.LLen("job_queue").Val()
+		// Update jobs in queue, per lane and in total
+		var queueLength int64
+		for _, lane := range priorityLanes {
+			laneLength := redisClient.LLen(lane.Queue).Val()
+			jobsInQueueByPriority.WithLabelValues(lane.Priority).Set(float64(laneLength))
+			queueLength += laneLength
+		}
 		jobsInQueue.Set(float64(queueLength))
 
 		// Update jobs in DLQ
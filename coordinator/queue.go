@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// PriorityLane is a Redis list holding jobs of a single priority
+type PriorityLane struct {
+	Priority string
+	Queue    string
+	Weight   int
+}
+
+// Lanes in strict priority order. Weights give the share of dispatches each lane
+// gets while all of them have work, so low priority jobs are never starved
+var priorityLanes = []PriorityLane{
+	{Priority: "high", Queue: "job_queue:high", Weight: 6},
+	{Priority: "normal", Queue: "job_queue:normal", Weight: 3},
+	{Priority: "low", Queue: "job_queue:low", Weight: 1},
+}
+
+const DEFAULT_PRIORITY = "normal"
+
+func isValidPriority(priority string) bool {
+	for _, lane := range priorityLanes {
+		if lane.Priority == priority {
+			return true
+		}
+	}
+	return false
+}
+
+func queueForPriority(priority string) string {
+	for _, lane := range priorityLanes {
+		if lane.Priority == priority {
+			return lane.Queue
+		}
+	}
+	return queueForPriority(DEFAULT_PRIORITY)
+}
+
+func enqueueJob(job Job) error {
+	// Push the job to the back of its priority lane
+	jobJson, _ := json.Marshal(job)
+	return redisClient.LPush(queueForPriority(job.Priority), jobJson).Err()
+}
+
+func commitAndEnqueue(tx *sql.Tx, jobs ...Job) error {
+	// Commit the transaction that made the jobs pending, then queue them. Only pending
+	// jobs are leased, so they are pushed once that is visible. A failed push leaves a
+	// pending job without a queue entry, which the reconciler requeues, it is logged
+	// rather than returned as the transaction already committed
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if err := enqueueJob(job); err != nil {
+			fmt.Println("Error enqueueing job", job.ID, err)
+		}
+	}
+	return nil
+}
+
+// laneScheduler implements smooth weighted round robin over the priority lanes
+type laneScheduler struct {
+	credits []int
+}
+
+func newLaneScheduler() *laneScheduler {
+	return &laneScheduler{credits: make([]int, len(priorityLanes))}
+}
+
+func (s *laneScheduler) nextOrder() []string {
+	// Pick the lane owed the most turns, then fall back to the rest in priority order
+	// so an empty preferred lane never blocks work waiting in the others
+	total, preferred := 0, 0
+	for i, lane := range priorityLanes {
+		s.credits[i] += lane.Weight
+		total += lane.Weight
+		if s.credits[i] > s.credits[preferred] {
+			preferred = i
+		}
+	}
+	s.credits[preferred] -= total
+
+	queues := []string{priorityLanes[preferred].Queue}
+	for i, lane := range priorityLanes {
+		if i != preferred {
+			queues = append(queues, lane.Queue)
+		}
+	}
+	return queues
+}
+
+func (s *laneScheduler) pop(timeout time.Duration) (string, error) {
+	// BRPop returns from the first non-empty queue in the given order
+	result, err := redisClient.BRPop(timeout, s.nextOrder()...).Result()
+	if err != nil {
+		return "", err
+	}
+	return result[1], nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ReconcileReport lists what a reconciliation pass repaired
+type ReconcileReport struct {
+	Trigger           string    `json:"trigger"`
+	StartedAt         time.Time `json:"started_at"`
+	FinishedAt        time.Time `json:"finished_at"`
+	RequeuedJobs      []string  `json:"requeued_jobs"`
+	ScheduledRestored int       `json:"scheduled_restored"`
+	ReleasedWorkers   []string  `json:"released_workers"`
+	Errors            []string  `json:"errors"`
+}
+
+// Only one reconciliation runs at a time, whether from the loop or the admin endpoint
+var reconcileMutex sync.Mutex
+
+func reconcileLoop() {
+	for {
+		time.Sleep(5 * time.Minute)
+		reconcile("periodic")
+	}
+}
+
+func reconcile(trigger string) ReconcileReport {
+	reconcileMutex.Lock()
+	defer reconcileMutex.Unlock()
+
+	report := ReconcileReport{
+		Trigger:         trigger,
+		StartedAt:       time.Now().UTC(),
+		RequeuedJobs:    []string{},
+		ReleasedWorkers: []string{},
+		Errors:          []string{},
+	}
+
+	recordError := func(err error) {
+		fmt.Println("Reconcile error:", err)
+		report.Errors = append(report.Errors, err.Error())
+	}
+
+	// Pending jobs missing from every priority lane, e.g. after Redis was flushed
+	requeued, err := requeueOrphanedJobs()
+	if err != nil {
+		recordError(err)
+	}
+	report.RequeuedJobs = append(report.RequeuedJobs, requeued...)
+	reconcileRepairs.WithLabelValues("requeued_job").Add(float64(len(requeued)))
+
+	// Scheduled jobs and retry backoffs missing from the scheduled set
+	report.ScheduledRestored = loadScheduledJobs()
+	reconcileRepairs.WithLabelValues("scheduled_restored").Add(float64(report.ScheduledRestored))
+
+	// Workers left busy without a leased job never get picked again
+	rows, err := db.Query(`
+		UPDATE workers SET state = 'available'
+		WHERE state = 'busy' AND NOT EXISTS (
+			SELECT 1 FROM jobs WHERE status = 'leased' AND leased_to_worker = workers.url
+		)
+		RETURNING url
+	`)
+	if err != nil {
+		recordError(fmt.Errorf("releasing stale busy workers: %v", err))
+	} else {
+		for rows.Next() {
+			var url string
+			if err := rows.Scan(&url); err == nil {
+				report.ReleasedWorkers = append(report.ReleasedWorkers, url)
+			}
+		}
+		rows.Close()
+	}
+	reconcileRepairs.WithLabelValues("released_worker").Add(float64(len(report.ReleasedWorkers)))
+
+	report.FinishedAt = time.Now().UTC()
+
+	fmt.Printf("Reconcile (%s): requeued %d jobs, restored %d scheduled jobs, released %d workers\n",
+		trigger, len(report.RequeuedJobs), report.ScheduledRestored, len(report.ReleasedWorkers))
+
+	return report
+}
+
+func requeueOrphanedJobs() ([]string, error) {
+	// Collect ids of every job currently waiting in a lane
+	queued := make(map[string]bool)
+
+	for _, lane := range priorityLanes {
+		entries, err := redisClient.LRange(lane.Queue, 0, -1).Result()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", lane.Queue, err)
+		}
+
+		for _, entry := range entries {
+			var job Job
+			if err := json.Unmarshal([]byte(entry), &job); err == nil {
+				queued[job.ID] = true
+			}
+		}
+	}
+
+	// Pending jobs waiting out a retry backoff live in the scheduled set instead, and jobs
+	// still in the outbox are pushed by the submitter's relay
+	rows, err := db.Query(`
+		SELECT id, name, payload, priority FROM jobs
+		WHERE status = 'pending' AND next_attempt_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM job_outbox WHERE job_outbox.job_id = jobs.id)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("querying pending jobs: %v", err)
+	}
+
+	var orphaned []Job
+	for rows.Next() {
+		var job Job
+		if err := rows.Scan(&job.ID, &job.Name, &job.Payload, &job.Priority); err != nil {
+			fmt.Println("Error scanning job row:", err)
+			continue
+		}
+		if !queued[job.ID] {
+			orphaned = append(orphaned, job)
+		}
+	}
+
+	rows.Close()
+
+	// A job popped by distributeJobs but not yet leased, e.g. while it waits for a free
+	// worker, may be queued twice. The duplicate is skipped because only pending jobs
+	// without a next_attempt_at are leased, so it cannot start a retry early either
+	requeued := []string{}
+	for _, job := range orphaned {
+		if err := enqueueJob(job); err != nil {
+			return requeued, fmt.Errorf("requeueing job %s: %v", job.ID, err)
+		}
+		requeued = append(requeued, job.ID)
+	}
+
+	return requeued, nil
+}
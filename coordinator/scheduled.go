@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// The scheduled set holds every job waiting for a point in time: jobs submitted with
+// run_at (status scheduled) and pending jobs with next_attempt_at, either failed jobs
+// waiting out their retry backoff or jobs deferred because their concurrency key was
+// busy. Postgres is the source of truth, the set is rebuilt from it
+
+// How long a job waits before trying again when its concurrency key is at the limit
+const CONCURRENCY_DEFER_DELAY = 2 * time.Second
+
+// Longest wait between two checks of the scheduled set, the releaser wakes up earlier
+// when its first entry is due sooner, e.g. the start slots of a rate limited job name
+const SCHEDULED_POLL_INTERVAL = 1 * time.Second
+
+// Shortest wait, keeps an entry that could not be released from turning the wait into a busy loop
+const SCHEDULED_MIN_WAIT = 50 * time.Millisecond
+
+// Removes an entry only if it still has the score it was fetched with. A job re-parked
+// with a new score in the meantime, e.g. deferred again, keeps its new entry
+var removeScheduledEntry = redis.NewScript(`
+	local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+	if score and tonumber(score) == tonumber(ARGV[2]) then
+		return redis.call('ZREM', KEYS[1], ARGV[1])
+	end
+	return 0
+`)
+
+func scheduledScore(t time.Time) float64 {
+	// Scores keep sub-second precision, rate limited jobs are deferred to start slots
+	// less than a second apart
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+func loadScheduledJobs() int {
+	// Rebuild the scheduled set from Postgres so delayed jobs survive a Redis restart,
+	// returns the number of jobs that were missing from the set
+	rows, err := db.Query(`
+		SELECT id, COALESCE(next_attempt_at, run_at) FROM jobs
+		WHERE status = 'scheduled' OR (status = 'pending' AND next_attempt_at IS NOT NULL)
+	`)
+	if err != nil {
+		fmt.Println("Error loading scheduled jobs:", err)
+		return 0
+	}
+	defer rows.Close()
+
+	loaded := 0
+	for rows.Next() {
+		var jobID int
+		var runAt time.Time
+		if err := rows.Scan(&jobID, &runAt); err != nil {
+			fmt.Println("Error scanning scheduled job row:", err)
+			continue
+		}
+
+		added, err := redisClient.ZAdd(SCHEDULED_QUEUE, redis.Z{
+			Score:  scheduledScore(runAt),
+			Member: strconv.Itoa(jobID),
+		}).Result()
+
+		if err != nil {
+			fmt.Println("Error adding scheduled job", jobID, "to redis:", err)
+			continue
+		}
+		loaded += int(added)
+	}
+
+	if loaded > 0 {
+		fmt.Println("Loaded", loaded, "scheduled jobs")
+	}
+	return loaded
+}
+
+func releaseScheduledJobs() {
+	lastLoad := time.Now()
+
+	for {
+		// Periodically resync with Postgres in case an entry never made it to Redis
+		if time.Since(lastLoad) > time.Minute {
+			loadScheduledJobs()
+			lastLoad = time.Now()
+		}
+
+		// Fetch a batch of jobs whose run_at has passed
+		entries, err := redisClient.ZRangeByScoreWithScores(SCHEDULED_QUEUE, redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatFloat(scheduledScore(time.Now()), 'f', -1, 64),
+			Count: 100,
+		}).Result()
+
+		if err != nil {
+			fmt.Println("Error fetching due scheduled jobs:", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		for _, entry := range entries {
+			releaseScheduledJob(entry.Member.(string), entry.Score)
+		}
+
+		// A full batch means more jobs are due, otherwise sleep until the next one is
+		if len(entries) == 100 {
+			continue
+		}
+		next, err := redisClient.ZRangeWithScores(SCHEDULED_QUEUE, 0, 0).Result()
+		if err != nil || len(next) == 0 {
+			time.Sleep(SCHEDULED_POLL_INTERVAL)
+			continue
+		}
+		time.Sleep(scheduledWait(time.Now(), next[0].Score))
+	}
+}
+
+func scheduledWait(now time.Time, nextScore float64) time.Duration {
+	// Time until the entry with nextScore is due, within the poll interval bounds
+	wait := time.Duration((nextScore - scheduledScore(now)) * float64(time.Second))
+	return min(max(wait, SCHEDULED_MIN_WAIT), SCHEDULED_POLL_INTERVAL)
+}
+
+func releaseScheduledJob(jobID string, score float64) {
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("Error starting transaction: ", err)
+		return
+	}
+
+	defer tx.Rollback()
+
+	// Move the job to pending, the status guard makes sure only one coordinator
+	// releases it and that cancelled jobs stay cancelled
+	var job Job
+	err = transitionJob(tx, JobTransition{
+		From:   []string{"scheduled"},
+		To:     "pending",
+		Reason: "released",
+		Set:    "next_attempt_at = NULL",
+		Where:  "id = $4",
+		Args:   []interface{}{jobID},
+	}, "id, name, payload, priority", &job.ID, &job.Name, &job.Payload, &job.Priority)
+
+	if err == sql.ErrNoRows {
+		// Retries and deferrals are already pending, releasing them is not a transition
+		err = tx.QueryRow(`
+			UPDATE jobs SET next_attempt_at = NULL
+			WHERE id = $1 AND status = 'pending' AND next_attempt_at IS NOT NULL
+			RETURNING id, name, payload, priority
+		`, jobID).Scan(&job.ID, &job.Name, &job.Payload, &job.Priority)
+	}
+
+	if err != nil && err != sql.ErrNoRows {
+		// Leave it in the set, it is retried on the next poll
+		fmt.Println("Error releasing scheduled job:", jobID, err)
+		return
+	}
+
+	// The entry is removed before the job is queued, once queued the dispatcher may park
+	// it again under the same member. Only the entry that was fetched is removed. Should
+	// the commit fail, the job is still waiting in Postgres and the resync adds it back
+	err = removeScheduledEntry.Run(redisClient, []string{SCHEDULED_QUEUE}, jobID, strconv.FormatFloat(score, 'f', -1, 64)).Err()
+	if err != nil && err != redis.Nil {
+		fmt.Println("Error removing scheduled job", jobID, "from redis:", err)
+	}
+
+	if job.ID != "" {
+		if err := commitAndEnqueue(tx, job); err != nil {
+			fmt.Println("Error committing transaction:", err)
+			return
+		}
+		fmt.Println("Released scheduled job", jobID)
+	}
+}
+
+func scheduleRetry(jobID string, leaseToken int, delay time.Duration) (bool, error) {
+	// Persist the retry time before adding it to the set, so a coordinator restart
+	// during the backoff does not lose the retry. Only the lease that failed is retried,
+	// the job may have been leased again since the failure was observed. Reports whether
+	// the job was moved back to pending, even when adding it to the set failed
+	nextAttemptAt := time.Now().Add(delay).UTC()
+
+	err := transitionJob(db, JobTransition{
+		From:   []string{"leased"},
+		To:     "pending",
+		Reason: "retry",
+		Set:    "lease_start = NULL, lease_renewed_at = NULL, lease_timeout = NULL, retries = retries + 1, next_attempt_at = $6",
+		Where:  "id = $4 AND lease_token = $5",
+		Args:   []interface{}{jobID, leaseToken, nextAttemptAt},
+	}, "")
+
+	if err == sql.ErrNoRows {
+		// Cancelled or already retried since the failure was observed
+		fmt.Println("Job", jobID, "is no longer leased, not scheduling retry")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// A failure here is repaired by the periodic resync from Postgres
+	return true, redisClient.ZAdd(SCHEDULED_QUEUE, redis.Z{
+		Score:  scheduledScore(nextAttemptAt),
+		Member: jobID,
+	}).Err()
+}
+
+func deferJob(jobID string, delay time.Duration) error {
+	// Push back a pending job without counting an attempt, it is released from the
+	// scheduled set like a retry
+	nextAttemptAt := time.Now().Add(delay).UTC()
+
+	res, err := db.Exec(
+		"UPDATE jobs SET next_attempt_at = $2 WHERE id = $1 AND status = 'pending'",
+		jobID, nextAttemptAt,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return nil
+	}
+
+	return redisClient.ZAdd(SCHEDULED_QUEUE, redis.Z{
+		Score:  scheduledScore(nextAttemptAt),
+		Member: jobID,
+	}).Err()
+}
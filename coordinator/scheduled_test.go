@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduledWait(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		next time.Time
+		want time.Duration
+	}{
+		{now.Add(300 * time.Millisecond), 300 * time.Millisecond},
+		{now.Add(10 * time.Millisecond), SCHEDULED_MIN_WAIT},
+		{now.Add(-time.Second), SCHEDULED_MIN_WAIT},
+		{now.Add(time.Hour), SCHEDULED_POLL_INTERVAL},
+	}
+
+	for _, tt := range tests {
+		got := scheduledWait(now, scheduledScore(tt.next))
+		// Scores are floats, allow for rounding
+		if diff := got - tt.want; diff < -time.Millisecond || diff > time.Millisecond {
+			t.Errorf("scheduledWait(%v) = %v, want %v", tt.next.Sub(now), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// Schedule is a recurring job definition from the schedules table
+type Schedule struct {
+	ID              int        `json:"id"`
+	Name            string     `json:"name"`
+	CronExpression  string     `json:"cron_expression"`
+	Timezone        string     `json:"timezone"`
+	JobName         string     `json:"job_name"`
+	PayloadTemplate string     `json:"payload_template"`
+	Priority        string     `json:"priority"`
+	Enabled         bool       `json:"enabled"`
+	MissedRunPolicy string     `json:"missed_run_policy"` // skip, run_once or catch_up
+	AllowOverlap    bool       `json:"allow_overlap"`
+	NextRunAt       *time.Time `json:"next_run_at"`
+	LastRunAt       *time.Time `json:"last_run_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// PayloadData is the data available to a schedule's payload template
+type PayloadData struct {
+	ScheduleID    int
+	ScheduleName  string
+	ScheduledTime time.Time
+}
+
+const scheduleColumns = `id, name, cron_expression, timezone, job_name, payload_template, priority,
+	enabled, missed_run_policy, allow_overlap, next_run_at, last_run_at, created_at`
+
+var missedRunPolicies = map[string]bool{
+	"skip":     true,
+	"run_once": true,
+	"catch_up": true,
+}
+
+const (
+	SCHEDULE_MISFIRE_GRACE = time.Minute // a due run later than this counts as missed
+	MAX_CATCH_UP_RUNS      = 100         // occurrences handled for one schedule in a single pass
+)
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSchedule(row rowScanner) (Schedule, error) {
+	var s Schedule
+	err := row.Scan(
+		&s.ID, &s.Name, &s.CronExpression, &s.Timezone, &s.JobName, &s.PayloadTemplate, &s.Priority,
+		&s.Enabled, &s.MissedRunPolicy, &s.AllowOverlap, &s.NextRunAt, &s.LastRunAt, &s.CreatedAt,
+	)
+	return s, err
+}
+
+func validateSchedule(s *Schedule) error {
+	if s.Name == "" || s.JobName == "" {
+		return fmt.Errorf("name and job_name are required")
+	}
+	if _, err := parseCron(s.CronExpression); err != nil {
+		return fmt.Errorf("invalid cron_expression: %v", err)
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone: %v", err)
+	}
+	if _, err := template.New("payload").Parse(s.PayloadTemplate); err != nil {
+		return fmt.Errorf("invalid payload_template: %v", err)
+	}
+	if !missedRunPolicies[s.MissedRunPolicy] {
+		return fmt.Errorf("missed_run_policy must be one of skip, run_once or catch_up")
+	}
+	if !isValidPriority(s.Priority) {
+		return fmt.Errorf("priority must be one of high, normal or low")
+	}
+	return nil
+}
+
+func nextScheduleRun(s Schedule, after time.Time) (time.Time, error) {
+	// Evaluate the cron expression in the schedule's timezone, store the result in UTC
+	cron, err := parseCron(s.CronExpression)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	next := cron.Next(after.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression %q never fires", s.CronExpression)
+	}
+	return next.UTC(), nil
+}
+
+func runSchedules() {
+	for {
+		// Find schedules with a due run, each is materialized in its own transaction
+		rows, err := db.Query(
+			"SELECT id FROM schedules WHERE enabled AND next_run_at <= $1 ORDER BY next_run_at",
+			time.Now().UTC(),
+		)
+
+		if err != nil {
+			fmt.Println("Error querying due schedules:", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		var scheduleIDs []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				fmt.Println("Error scanning schedule row:", err)
+				continue
+			}
+			scheduleIDs = append(scheduleIDs, id)
+		}
+
+		rows.Close()
+
+		for _, id := range scheduleIDs {
+			if err := materializeSchedule(id); err != nil {
+				fmt.Println("Error materializing schedule", id, err)
+			}
+		}
+
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func materializeSchedule(scheduleID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	// SKIP LOCKED lets several coordinators share the work without double firing
+	schedule, err := scanSchedule(tx.QueryRow(`
+		SELECT `+scheduleColumns+` FROM schedules
+		WHERE id = $1 AND enabled AND next_run_at <= $2
+		FOR UPDATE SKIP LOCKED
+	`, scheduleID, now))
+
+	if err == sql.ErrNoRows {
+		// Already handled by another coordinator, or paused in the meantime
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Collect every occurrence that is due
+	var dueRuns []time.Time
+	run := *schedule.NextRunAt
+	for !run.After(now) && len(dueRuns) < MAX_CATCH_UP_RUNS {
+		dueRuns = append(dueRuns, run)
+		run, err = nextScheduleRun(schedule, run)
+		if err != nil {
+			return err
+		}
+	}
+	latest := dueRuns[len(dueRuns)-1]
+	nextRunAt := run
+
+	// Overlap protection, a run is still active if any job it created has not finished
+	active := false
+	if !schedule.AllowOverlap {
+		err = tx.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM jobs WHERE schedule_id = $1 AND status IN ('scheduled', 'pending', 'leased')
+			)
+		`, schedule.ID).Scan(&active)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Decide which occurrences become jobs according to the missed run policy
+	var runs []time.Time
+
+	switch {
+	case schedule.MissedRunPolicy == "catch_up" && !schedule.AllowOverlap:
+		if active {
+			// Keep the backlog, it is worked through once the active run finishes
+			return nil
+		}
+		// Work through the backlog one run at a time
+		runs = dueRuns[:1]
+		if len(dueRuns) > 1 {
+			nextRunAt = dueRuns[1]
+		}
+	case schedule.MissedRunPolicy == "catch_up":
+		runs = dueRuns
+	case active:
+		fmt.Println("Schedule", schedule.Name, "has an active run, skipping", len(dueRuns), "occurrence(s)")
+	case schedule.MissedRunPolicy == "run_once":
+		runs = []time.Time{latest}
+	default:
+		// skip, only fire if the latest occurrence is on time
+		if now.Sub(latest) <= SCHEDULE_MISFIRE_GRACE {
+			runs = []time.Time{latest}
+		} else {
+			fmt.Println("Schedule", schedule.Name, "missed", len(dueRuns), "occurrence(s), skipping")
+		}
+	}
+
+	tmpl, err := template.New("payload").Parse(schedule.PayloadTemplate)
+	if err != nil {
+		return err
+	}
+
+	var jobs []Job
+	for _, run := range runs {
+		var payload bytes.Buffer
+		err := tmpl.Execute(&payload, PayloadData{
+			ScheduleID:    schedule.ID,
+			ScheduleName:  schedule.Name,
+			ScheduledTime: run,
+		})
+		if err != nil {
+			return fmt.Errorf("rendering payload for schedule %s: %v", schedule.Name, err)
+		}
+
+		job := Job{Name: schedule.JobName, Payload: payload.String(), Priority: schedule.Priority}
+		var jobID int
+		err = tx.QueryRow(
+			"INSERT INTO jobs (name, payload, priority, schedule_id) VALUES ($1, $2, $3, $4) RETURNING id",
+			job.Name, job.Payload, job.Priority, schedule.ID,
+		).Scan(&jobID)
+		if err != nil {
+			return err
+		}
+
+		job.ID = strconv.Itoa(jobID)
+		jobs = append(jobs, job)
+	}
+
+	lastRunAt := schedule.LastRunAt
+	if len(runs) > 0 {
+		lastRunAt = &runs[len(runs)-1]
+	}
+
+	_, err = tx.Exec(
+		"UPDATE schedules SET next_run_at = $1, last_run_at = $2 WHERE id = $3",
+		nextRunAt, lastRunAt, schedule.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := commitAndEnqueue(tx, jobs...); err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		fmt.Println("Schedule", schedule.Name, "created job", job.ID)
+	}
+
+	return nil
+}
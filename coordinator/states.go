@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Job statuses only change through transitionJob and transitionJobs, which check the
+// move against jobTransitions, apply it with a compare-and-set on the current status and
+// log it to the job_events table in the same statement. Two writers racing for the same
+// job (e.g. leaseMonitor and processJobResults) cannot both move it, the loser sees no
+// row. The submitter's cancel is forwarded to the coordinator's cancelJob. Job creation is
+// logged by the jobs_created trigger
+
+// Statuses a job may move to from each status
+var jobTransitions = map[string][]string{
+	"scheduled":       {"pending", "cancelled"},
+	"queued":          {"pending", "cancelled"},
+	"blocked":         {"pending", "upstream_failed", "cancelled"},
+	"pending":         {"leased", "cancelled"},
+	"leased":          {"completed", "failed", "pending", "cancelled"},
+	"failed":          {"pending"}, // replayed from the dead letters
+	"completed":       {},
+	"cancelled":       {},
+	"upstream_failed": {},
+}
+
+// Returned for a move jobTransitions does not allow, without touching the database
+var errIllegalTransition = errors.New("illegal job status transition")
+
+// JobTransition moves the jobs matching Where from one of the From statuses to To
+type JobTransition struct {
+	From   []string
+	To     string
+	Reason string // why the job moved, recorded in job_events
+	Set    string // further assignments made with the move, e.g. "completed_at = NOW()"
+	Where  string // which jobs to move, its placeholders and Set's start at $4
+	Args   []interface{}
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (t JobTransition) query(returning string) (string, []interface{}, error) {
+	for _, from := range t.From {
+		allowed := false
+		for _, to := range jobTransitions[from] {
+			allowed = allowed || to == t.To
+		}
+		if !allowed {
+			return "", nil, fmt.Errorf("%w: %s -> %s", errIllegalTransition, from, t.To)
+		}
+	}
+
+	set := ""
+	if t.Set != "" {
+		set = ", " + t.Set
+	}
+	if returning == "" {
+		returning = "id"
+	}
+
+	// The rows are locked in their expected status before they are moved, so the move
+	// and the from_status logged with it are both taken from the same locked row. The
+	// returning columns are read from the moved row, previous_status is also available
+	query := `
+		WITH previous AS (
+			SELECT id AS previous_id, status AS previous_status FROM jobs
+			WHERE status = ANY($1) AND (` + t.Where + `)
+			FOR UPDATE
+		), moved AS (
+			UPDATE jobs SET status = $2` + set + `
+			FROM previous WHERE id = previous_id
+			RETURNING jobs.*, previous_status
+		), logged AS (
+			INSERT INTO job_events (job_id, from_status, to_status, reason)
+			SELECT id, previous_status, $2::text, $3::text FROM moved
+		)
+		SELECT ` + returning + ` FROM moved`
+
+	args := append([]interface{}{pq.Array(t.From), t.To, t.Reason}, t.Args...)
+	return query, args, nil
+}
+
+func transitionJob(q queryer, t JobTransition, returning string, dest ...interface{}) error {
+	// Move a single job, sql.ErrNoRows means it was not in one of the From statuses
+	query, args, err := t.query(returning)
+	if err != nil {
+		return err
+	}
+
+	if len(dest) == 0 {
+		var id int
+		dest = []interface{}{&id}
+	}
+	return q.QueryRow(query, args...).Scan(dest...)
+}
+
+func transitionJobs(q queryer, t JobTransition, returning string) (*sql.Rows, error) {
+	query, args, err := t.query(returning)
+	if err != nil {
+		return nil, err
+	}
+	return q.Query(query, args...)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestJobTransitionsTable(t *testing.T) {
+	for from, targets := range jobTransitions {
+		for _, to := range targets {
+			if _, ok := jobTransitions[to]; !ok {
+				t.Errorf("%s -> %s: %s is not a known status", from, to, to)
+			}
+			if to == from {
+				t.Errorf("%s -> %s: a status cannot move to itself", from, to)
+			}
+		}
+	}
+
+	for _, status := range []string{"completed", "cancelled", "upstream_failed"} {
+		if targets, ok := jobTransitions[status]; !ok || len(targets) != 0 {
+			t.Errorf("terminal status %s has transitions %v", status, targets)
+		}
+	}
+}
+
+func TestJobTransitionQuery(t *testing.T) {
+	tests := []struct {
+		from    []string
+		to      string
+		allowed bool
+	}{
+		{[]string{"pending"}, "leased", true},
+		{[]string{"leased"}, "completed", true},
+		{[]string{"leased"}, "pending", true},
+		{[]string{"scheduled"}, "pending", true},
+		{[]string{"blocked"}, "upstream_failed", true},
+		{[]string{"failed"}, "pending", true},
+		{[]string{"pending", "scheduled", "queued", "blocked", "leased"}, "cancelled", true},
+		{[]string{"failed"}, "leased", false},
+		{[]string{"pending"}, "completed", false},
+		{[]string{"scheduled"}, "leased", false},
+		{[]string{"completed"}, "pending", false},
+		{[]string{"cancelled"}, "pending", false},
+		{[]string{"upstream_failed"}, "pending", false},
+		{[]string{"pending", "completed"}, "cancelled", false},
+		{[]string{"unknown"}, "pending", false},
+	}
+
+	for _, tt := range tests {
+		transition := JobTransition{From: tt.from, To: tt.to, Reason: "test", Where: "id = $4", Args: []interface{}{1}}
+		query, args, err := transition.query("")
+
+		if !tt.allowed {
+			if !errors.Is(err, errIllegalTransition) {
+				t.Errorf("%v -> %s: error = %v, want errIllegalTransition", tt.from, tt.to, err)
+			}
+			if query != "" {
+				t.Errorf("%v -> %s: query built for an illegal transition", tt.from, tt.to)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v -> %s: unexpected error %v", tt.from, tt.to, err)
+			continue
+		}
+		if len(args) != 4 || args[1] != tt.to || args[2] != "test" || args[3] != 1 {
+			t.Errorf("%v -> %s: args = %v", tt.from, tt.to, args)
+		}
+		if !strings.Contains(query, "AND (id = $4)") || !strings.Contains(query, "SELECT id FROM moved") {
+			t.Errorf("%v -> %s: unexpected query %s", tt.from, tt.to, query)
+		}
+	}
+}
+
+func TestJobTransitionQuerySet(t *testing.T) {
+	transition := JobTransition{
+		From:   []string{"leased"},
+		To:     "completed",
+		Reason: "completed",
+		Set:    "completed_at = NOW()",
+		Where:  "id = $4",
+		Args:   []interface{}{1},
+	}
+
+	query, _, err := transition.query("name, previous_status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(query, "SET status = $2, completed_at = NOW()") {
+		t.Errorf("Set not applied with the move: %s", query)
+	}
+	if !strings.Contains(query, "SELECT name, previous_status FROM moved") {
+		t.Errorf("returning columns not selected: %s", query)
+	}
+}
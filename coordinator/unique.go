@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Jobs submitted with unique_policy queue_after wait in the queued status until no
+// other job with the same name and unique_key is active
+
+func promoteQueuedJobs() {
+	for {
+		rows, err := db.Query("SELECT DISTINCT name, unique_key FROM jobs WHERE status = 'queued'")
+		if err != nil {
+			fmt.Println("Error querying queued jobs:", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		type uniqueKey struct {
+			Name string
+			Key  string
+		}
+
+		var keys []uniqueKey
+		for rows.Next() {
+			var key uniqueKey
+			if err := rows.Scan(&key.Name, &key.Key); err != nil {
+				fmt.Println("Error scanning queued job row:", err)
+				continue
+			}
+			keys = append(keys, key)
+		}
+
+		rows.Close()
+
+		for _, key := range keys {
+			if err := promoteQueuedJob(key.Name, key.Key); err != nil {
+				fmt.Println("Error promoting queued job for unique key", key.Key, err)
+			}
+		}
+
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func promoteQueuedJob(name string, uniqueKey string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// Same lock the submitter takes while resolving the unique policy
+	_, err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1 || ':' || $2))", name, uniqueKey)
+	if err != nil {
+		return err
+	}
+
+	// Move the oldest queued job to pending once nothing with this key is active
+	var job Job
+	err = transitionJob(tx, JobTransition{
+		From:   []string{"queued"},
+		To:     "pending",
+		Reason: "promoted",
+		Where: `id = (
+				SELECT id FROM jobs WHERE name = $4 AND unique_key = $5 AND status = 'queued'
+				ORDER BY id
+				LIMIT 1
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM jobs
+				WHERE name = $4 AND unique_key = $5 AND status IN ('scheduled', 'pending', 'leased')
+			)`,
+		Args: []interface{}{name, uniqueKey},
+	}, "id, name, payload, priority", &job.ID, &job.Name, &job.Payload, &job.Priority)
+
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := commitAndEnqueue(tx, job); err != nil {
+		return err
+	}
+
+	fmt.Println("Promoted queued job", job.ID, "for unique key", uniqueKey)
+	return nil
+}
@@ -6,9 +6,18 @@ import (
 	"time"
 )
 
-func calculateBackoffDelay(retryCount int) time.Duration {
-	// Exponentail Backoff: 2 ^ retryCount seconds with jitter
-	baseDelay := time.Duration(math.Pow(2, float64(retryCount))) * time.Second
+func calculateBackoffDelay(policy RetryPolicy, retryCount int) time.Duration {
+	baseDelay := time.Duration(policy.BaseSeconds) * time.Second
+
+	switch policy.Backoff {
+	case "fixed":
+		// Same delay for every retry
+	case "linear":
+		baseDelay *= time.Duration(retryCount + 1)
+	default:
+		// Exponentail Backoff: base * 2 ^ retryCount seconds with jitter
+		baseDelay = time.Duration(math.Pow(2, float64(retryCount))) * baseDelay
+	}
 
 	// Add jitter to prevent thundering herd
 	// Having fixed delay may cause multiple failure jobs access same resources at exactly same time
@@ -16,7 +25,7 @@ func calculateBackoffDelay(retryCount int) time.Duration {
 	jitter := time.Duration(rand.Intn(1000)) * time.Millisecond
 
 	// Cap at maximum delay
-	maxDelay := 60 * time.Second
+	maxDelay := time.Duration(policy.MaxSeconds) * time.Second
 	if baseDelay > maxDelay {
 		baseDelay = maxDelay
 	}
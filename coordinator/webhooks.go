@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Webhook events are written to the webhook_deliveries table and delivered by
+// webhookDispatcher, failed deliveries are retried with backoff until they succeed or
+// run out of attempts. Every request is signed with WEBHOOK_SECRET:
+//
+//	X-Webhook-Signature: t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+
+// JobEvent is the body of a job webhook
+type JobEvent struct {
+	Event       string     `json:"event"` // job.completed or job.failed
+	JobID       int        `json:"job_id"`
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	Result      *string    `json:"result"`
+	Retries     int        `json:"retries"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// WebhookDelivery is a row of the webhook_deliveries table
+type WebhookDelivery struct {
+	ID             int        `json:"id"`
+	JobID          *int       `json:"job_id"`
+	Url            string     `json:"url"`
+	Event          string     `json:"event"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"` // pending, delivered or failed
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at"`
+	LastStatusCode *int       `json:"last_status_code"`
+	LastError      *string    `json:"last_error"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+}
+
+const webhookDeliveryColumns = `id, job_id, url, event, payload, status, attempts, next_attempt_at,
+	last_status_code, last_error, created_at, delivered_at`
+
+const (
+	WEBHOOK_MAX_ATTEMPTS = 10
+	WEBHOOK_BATCH_SIZE   = 50
+	WEBHOOK_TIMEOUT      = 10 * time.Second
+)
+
+// Backoff between delivery attempts, 5s doubling up to an hour
+var webhookRetryPolicy = RetryPolicy{
+	MaxRetries:  WEBHOOK_MAX_ATTEMPTS,
+	Backoff:     "exponential",
+	BaseSeconds: 5,
+	MaxSeconds:  3600,
+}
+
+// Signing secret, read from WEBHOOK_SECRET on startup
+var webhookSecret string
+
+// Returned when a webhook is registered while no signing secret is configured
+var errNoWebhookSecret = errors.New("WEBHOOK_SECRET is not set on the coordinator, webhooks cannot be used")
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func scanWebhookDelivery(row rowScanner) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	err := row.Scan(
+		&d.ID, &d.JobID, &d.Url, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+		&d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt,
+	)
+	return d, err
+}
+
+func addWebhookDelivery(ex execer, jobID *int, url string, event string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	_, err = ex.Exec(
+		"INSERT INTO webhook_deliveries (job_id, url, event, payload) VALUES ($1, $2, $3, $4)",
+		jobID, url, event, string(payload),
+	)
+	return err
+}
+
+func queueJobWebhooks(jobID string) {
+	// Queue an event for the job's own callback_url and for every webhook registered
+	// for its name
+	var event JobEvent
+	var callbackUrl sql.NullString
+
+	err := db.QueryRow(
+		"SELECT id, name, status, result, retries, completed_at, callback_url FROM jobs WHERE id = $1", jobID,
+	).Scan(&event.JobID, &event.Name, &event.Status, &event.Result, &event.Retries, &event.CompletedAt, &callbackUrl)
+
+	if err != nil {
+		fmt.Println("Error loading job", jobID, "for webhooks:", err)
+		return
+	}
+
+	event.Event = "job." + event.Status
+
+	var urls []string
+	if callbackUrl.Valid {
+		urls = append(urls, callbackUrl.String)
+	}
+
+	rows, err := db.Query("SELECT url FROM job_webhooks WHERE job_name = $1", event.Name)
+	if err != nil {
+		fmt.Println("Error loading webhooks for", event.Name, err)
+		return
+	}
+
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err == nil {
+			urls = append(urls, url)
+		}
+	}
+
+	rows.Close()
+
+	for _, url := range urls {
+		if err := addWebhookDelivery(db, &event.JobID, url, event.Event, event); err != nil {
+			fmt.Println("Error queueing webhook for job", jobID, err)
+		}
+	}
+}
+
+func webhookDispatcher() {
+	// Requests are always signed, without a secret the deliveries stay pending until the
+	// coordinator is restarted with one
+	if webhookSecret == "" {
+		return
+	}
+
+	for {
+		delivered, err := dispatchWebhooks()
+		if err != nil {
+			fmt.Println("Error dispatching webhooks:", err)
+		}
+
+		// A full batch means more deliveries are due, fetch them without waiting
+		if delivered < WEBHOOK_BATCH_SIZE {
+			time.Sleep(1 * time.Second)
+		}
+	}
+}
+
+func dispatchWebhooks() (int, error) {
+	// Claim due deliveries by pushing their next attempt past the request timeout, so
+	// another coordinator does not send them at the same time. A delivery whose
+	// coordinator dies mid request is picked up again once the claim runs out
+	rows, err := db.Query(`
+		UPDATE webhook_deliveries SET next_attempt_at = NOW() + interval '1 minute'
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+webhookDeliveryColumns,
+		WEBHOOK_BATCH_SIZE,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	rows.Close()
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery WebhookDelivery) {
+			defer wg.Done()
+			deliverWebhook(delivery)
+		}(delivery)
+	}
+	wg.Wait()
+
+	return len(deliveries), nil
+}
+
+func deliverWebhook(delivery WebhookDelivery) {
+	statusCode, err := postWebhook(delivery)
+	attempts := delivery.Attempts + 1
+
+	var statusCodeArg interface{}
+	if statusCode != 0 {
+		statusCodeArg = statusCode
+	}
+
+	if err == nil {
+		_, err = db.Exec(`
+			UPDATE webhook_deliveries
+			SET status = 'delivered', attempts = $1, last_status_code = $2, last_error = NULL, delivered_at = NOW(), next_attempt_at = NULL
+			WHERE id = $3
+		`, attempts, statusCodeArg, delivery.ID)
+
+		if err != nil {
+			fmt.Println("Error recording webhook delivery", delivery.ID, err)
+		}
+		return
+	}
+
+	fmt.Println("Webhook delivery", delivery.ID, "to", delivery.Url, "failed:", err)
+
+	status := "pending"
+	var nextAttemptAt interface{} = time.Now().Add(calculateBackoffDelay(webhookRetryPolicy, delivery.Attempts)).UTC()
+	if attempts >= WEBHOOK_MAX_ATTEMPTS {
+		status, nextAttemptAt = "failed", nil
+		fmt.Println("Webhook delivery", delivery.ID, "gave up after", attempts, "attempts")
+	}
+
+	_, dbErr := db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, last_status_code = $3, last_error = $4, next_attempt_at = $5
+		WHERE id = $6
+	`, status, attempts, statusCodeArg, err.Error(), nextAttemptAt, delivery.ID)
+
+	if dbErr != nil {
+		fmt.Println("Error recording webhook delivery", delivery.ID, dbErr)
+	}
+}
+
+func postWebhook(delivery WebhookDelivery) (int, error) {
+	req, err := http.NewRequest("POST", delivery.Url, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", strconv.Itoa(delivery.ID))
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Signature", signWebhook(delivery.Payload, time.Now()))
+
+	client := &http.Client{Timeout: WEBHOOK_TIMEOUT}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return resp.StatusCode, nil
+}
+
+func signWebhook(payload string, at time.Time) string {
+	// The timestamp is signed with the body so receivers can reject replayed requests
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write([]byte(timestamp + "." + payload))
+
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"time"
 )
 
@@ -24,6 +25,10 @@ func updateWorkerState(workerUrl string, state string) error {
 	rows, _ := res.RowsAffected()
 	fmt.Println("updatedWorkerState: set workerUrl: ", workerUrl, "to state: ", state, "rows affected:", rows)
 
+	if rows > 0 {
+		publishWorkerEvent(workerUrl, state)
+	}
+
 	return nil
 
 }
@@ -94,12 +99,16 @@ func workerHeartbeatVerifier() {
 	}
 }
 
-func sendJobToWorker(workerUrl string, job Job) {
+func sendJobToWorker(lease Lease, job Job) {
+	workerUrl := lease.WorkerUrl
+
 	// Request payload
 	jobPayload := map[string]interface{}{
-		"job_id":  job.ID,
-		"name":    job.Name,
-		"payload": job.Payload,
+		"job_id":        job.ID,
+		"name":          job.Name,
+		"payload":       job.Payload,
+		"lease_timeout": lease.TimeoutSeconds,
+		"lease_token":   lease.Token,
 	}
 
 	payloadBytes, err := json.Marshal(jobPayload)
@@ -108,9 +117,11 @@ func sendJobToWorker(workerUrl string, job Job) {
 		return
 	}
 
-	// Create http client with timeout
+	// Create http client with timeout, workers answer once the job finishes so allow
+	// the whole lease plus some slack before giving up on the worker. Workers that renew
+	// their lease may run past it, see below
 	client := &http.Client{
-		Timeout: 30 * time.Second,
+		Timeout: time.Duration(lease.TimeoutSeconds)*time.Second + 10*time.Second,
 	}
 
 	// Mark worker as busy before sending Job Request
@@ -125,13 +136,19 @@ func sendJobToWorker(workerUrl string, job Job) {
 		bytes.NewBuffer(payloadBytes),
 	)
 
+	if err != nil && os.IsTimeout(err) && leaseHeld(job.ID, lease.Token) {
+		// The worker is still renewing its lease, its result arrives on the results queue
+		// and the lease monitor takes over if the renewals stop
+		fmt.Println("Job", job.ID, "is still running on worker", workerUrl, "with a renewed lease")
+		return
+	}
+
 	if err != nil {
 		fmt.Println("Error sending job to worker", workerUrl, err)
 		// Mark worker as unavailable
 		updateWorkerState(workerUrl, "unavailable")
 		// Requeue the job
-		jobJson, _ := json.Marshal(job)
-		redisClient.LPush("job_queue", jobJson)
+		requeueLeasedJob(job, lease.Token)
 		return
 	}
 
@@ -148,9 +165,38 @@ func sendJobToWorker(workerUrl string, job Job) {
 		fmt.Println("worker: ", workerUrl, "returned error for job: ", job.ID, "status:", resp.StatusCode, "body:", string(body))
 		// Requeue the job if worker rejected
 		time.Sleep(5 * time.Second)
-		jobJson, _ := json.Marshal(job)
-		redisClient.LPush("job_queue", jobJson)
+		requeueLeasedJob(job, lease.Token)
+	}
+}
+
+func cancelJobOnWorker(workerUrl string, jobID string) {
+	payloadBytes, err := json.Marshal(map[string]interface{}{
+		"job_id": jobID,
+	})
+	if err != nil {
+		fmt.Println("Error marshalling cancel payload:", err)
+		return
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	resp, err := client.Post(
+		workerUrl+"/cancel_job",
+		"application/json",
+		bytes.NewBuffer(payloadBytes),
+	)
+
+	if err != nil {
+		// Job is already cancelled in the database, its result will be ignored when it arrives
+		fmt.Println("Error sending cancel for job:", jobID, "to worker:", workerUrl, err)
+		return
 	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Println("Sent cancel for job:", jobID, "to worker:", workerUrl, "status:", resp.StatusCode, "response:", string(body))
 }
 
 func updateWorkerJobCount(workerUrl string) {
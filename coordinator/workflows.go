@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Workflow jobs wait in the blocked status until every job they depend on has completed.
+// A dependency that fails permanently or is cancelled marks all jobs downstream of it
+// upstream_failed
+
+func workflowMonitor() {
+	// Catch transitions the result handlers missed, e.g. two parents completing at once
+	// or a parent cancelled through the submitter
+	for {
+		if err := advanceWorkflows(0); err != nil {
+			fmt.Println("Error advancing workflows:", err)
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func advanceWorkflowsAfter(jobID string) {
+	// Called once a job reaches a terminal state, only its own children are looked at
+	id, err := strconv.Atoi(jobID)
+	if err != nil {
+		return
+	}
+	if err := advanceWorkflows(id); err != nil {
+		fmt.Println("Error advancing workflow after job", jobID, err)
+	}
+}
+
+func advanceWorkflows(parentID int) error {
+	// A parentID of 0 looks at every blocked job, otherwise only at the children of that job
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// Cascade failures through the whole subgraph below a failed parent
+	rows, err := transitionJobs(tx, JobTransition{
+		From:   []string{"blocked"},
+		To:     "upstream_failed",
+		Reason: "upstream_failed",
+		Set:    "completed_at = NOW()",
+		Where: `id IN (
+				WITH RECURSIVE doomed AS (
+					SELECT d.job_id FROM job_dependencies d
+					JOIN jobs parent ON parent.id = d.depends_on_job_id
+					JOIN jobs child ON child.id = d.job_id
+					WHERE parent.status IN ('failed', 'cancelled', 'upstream_failed') AND child.status = 'blocked'
+					AND ($4 = 0 OR parent.id = $4)
+					UNION
+					SELECT d.job_id FROM job_dependencies d
+					JOIN doomed ON d.depends_on_job_id = doomed.job_id
+				)
+				SELECT job_id FROM doomed
+			)`,
+		Args: []interface{}{parentID},
+	}, "id, name")
+
+	if err != nil {
+		return err
+	}
+
+	var failed []Job
+	for rows.Next() {
+		var job Job
+		if err := rows.Scan(&job.ID, &job.Name); err != nil {
+			rows.Close()
+			return err
+		}
+		failed = append(failed, job)
+	}
+
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Release children whose parents have all completed
+	rows, err = transitionJobs(tx, JobTransition{
+		From:   []string{"blocked"},
+		To:     "pending",
+		Reason: "unblocked",
+		Where: `($4 = 0 OR id IN (SELECT job_id FROM job_dependencies WHERE depends_on_job_id = $4))
+			AND NOT EXISTS (
+				SELECT 1 FROM job_dependencies d
+				JOIN jobs parent ON parent.id = d.depends_on_job_id
+				WHERE d.job_id = jobs.id AND parent.status <> 'completed'
+			)`,
+		Args: []interface{}{parentID},
+	}, "id, name, payload, priority")
+
+	if err != nil {
+		return err
+	}
+
+	var released []Job
+	for rows.Next() {
+		var job Job
+		if err := rows.Scan(&job.ID, &job.Name, &job.Payload, &job.Priority); err != nil {
+			rows.Close()
+			return err
+		}
+		released = append(released, job)
+	}
+
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := commitAndEnqueue(tx, released...); err != nil {
+		return err
+	}
+
+	for _, job := range released {
+		publishJobEvent("unblocked", job.ID, job.Name, "")
+		fmt.Println("Unblocked workflow job", job.ID)
+	}
+
+	// upstream_failed is terminal, the jobs get the same follow up as a job whose result
+	// came in: webhooks, their batch and the jobs downstream of them
+	for _, job := range failed {
+		jobsTotal.WithLabelValues("upstream_failed").Inc()
+		publishJobEvent("upstream_failed", job.ID, job.Name, "")
+		jobFinished(job.ID)
+	}
+	if len(failed) > 0 {
+		fmt.Println("Marked", len(failed), "workflow jobs upstream_failed")
+	}
+
+	return nil
+}
@@ -1,17 +1,222 @@
 
+CREATE TABLE IF NOT EXISTS schedules (
+    id SERIAL PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL,
+    cron_expression TEXT NOT NULL,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    job_name TEXT NOT NULL,
+    payload_template TEXT NOT NULL DEFAULT '',
+    priority TEXT NOT NULL DEFAULT 'normal',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    missed_run_policy TEXT NOT NULL DEFAULT 'skip',
+    allow_overlap BOOLEAN NOT NULL DEFAULT FALSE,
+    next_run_at TIMESTAMP,
+    last_run_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_schedules_next_run_at ON schedules (next_run_at) WHERE enabled;
+
+-- Groups of jobs submitted together with dependencies between them
+CREATE TABLE IF NOT EXISTS workflows (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Jobs submitted together whose completion is tracked as a whole, the finalizer job
+-- and the callback fire once every member has reached a terminal state
+CREATE TABLE IF NOT EXISTS batches (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    finalizer_job_name TEXT,
+    finalizer_payload TEXT,
+    finalizer_priority TEXT,
+    callback_url TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finalized_at TIMESTAMP,
+    finalizer_job_id INT
+);
+
 CREATE TABLE IF NOT EXISTS jobs (
     id SERIAL PRIMARY KEY,
     name TEXT NOT NULL,
     payload TEXT,
+    priority TEXT DEFAULT 'normal',
     status TEXT DEFAULT 'pending',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    run_at TIMESTAMP,
     lease_start TIMESTAMP,
+    lease_renewed_at TIMESTAMP,
     lease_timeout INT,
     leased_to_worker TEXT,
+    lease_token INT DEFAULT 0,
     completed_at TIMESTAMP,
     retries INT DEFAULT 0,
+    next_attempt_at TIMESTAMP,
+    start_slot_at TIMESTAMP,
     max_retries INT DEFAULT 3,
-    result TEXT
+    result TEXT,
+    lease_timeout_seconds INT DEFAULT 20,
+    backoff_policy TEXT DEFAULT 'exponential',
+    backoff_base_seconds INT DEFAULT 1,
+    backoff_max_seconds INT DEFAULT 60,
+    schedule_id INT REFERENCES schedules (id) ON DELETE SET NULL,
+    idempotency_key TEXT UNIQUE,
+    unique_key TEXT,
+    concurrency_key TEXT,
+    concurrency_limit INT DEFAULT 1,
+    workflow_id INT REFERENCES workflows (id) ON DELETE CASCADE,
+    workflow_node TEXT,
+    batch_id INT REFERENCES batches (id) ON DELETE CASCADE,
+    callback_url TEXT
+);
+
+-- One row per lease of a job, attempt is the lease_token the job was leased under
+CREATE TABLE IF NOT EXISTS job_attempts (
+    id SERIAL PRIMARY KEY,
+    job_id INT NOT NULL REFERENCES jobs (id) ON DELETE CASCADE,
+    attempt INT NOT NULL,
+    worker_url TEXT,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP,
+    outcome TEXT DEFAULT 'running',
+    error TEXT,
+    duration_seconds DOUBLE PRECISION,
+    UNIQUE (job_id, attempt)
+);
+
+-- Log of every job status transition, written with the transition itself. The first
+-- row of a job is its creation, with no from_status
+CREATE TABLE IF NOT EXISTS job_events (
+    id SERIAL PRIMARY KEY,
+    job_id INT NOT NULL REFERENCES jobs (id) ON DELETE CASCADE,
+    from_status TEXT,
+    to_status TEXT NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_events_job_id ON job_events (job_id, id);
+
+-- Jobs are inserted by both services in several places, logging the creation in a
+-- trigger starts every job's history at its initial status whoever inserted it
+CREATE OR REPLACE FUNCTION log_job_created() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO job_events (job_id, from_status, to_status, reason) VALUES (NEW.id, NULL, NEW.status, 'created');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER jobs_created AFTER INSERT ON jobs
+FOR EACH ROW EXECUTE FUNCTION log_job_created();
+
+-- Indexes backing the job listing filters, paired with id for cursor pagination
+CREATE INDEX IF NOT EXISTS idx_jobs_status_id ON jobs (status, id);
+CREATE INDEX IF NOT EXISTS idx_jobs_name_id ON jobs (name, id);
+CREATE INDEX IF NOT EXISTS idx_jobs_leased_to_worker_id ON jobs (leased_to_worker, id);
+CREATE INDEX IF NOT EXISTS idx_jobs_created_at ON jobs (created_at);
+CREATE INDEX IF NOT EXISTS idx_jobs_schedule_id_status ON jobs (schedule_id, status);
+CREATE INDEX IF NOT EXISTS idx_jobs_name_unique_key_status ON jobs (name, unique_key, status) WHERE unique_key IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_jobs_concurrency_key_status ON jobs (concurrency_key, status) WHERE concurrency_key IS NOT NULL;
+
+-- Workflow edges, a job stays blocked until every job it depends on has completed
+CREATE TABLE IF NOT EXISTS job_dependencies (
+    job_id INT NOT NULL REFERENCES jobs (id) ON DELETE CASCADE,
+    depends_on_job_id INT NOT NULL REFERENCES jobs (id) ON DELETE CASCADE,
+    PRIMARY KEY (job_id, depends_on_job_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_dependencies_depends_on ON job_dependencies (depends_on_job_id);
+CREATE INDEX IF NOT EXISTS idx_jobs_workflow_id ON jobs (workflow_id) WHERE workflow_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_jobs_batch_id_status ON jobs (batch_id, status) WHERE batch_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_batches_unfinalized ON batches (id) WHERE finalized_at IS NULL;
+
+-- Webhooks called for every job of a name once it reaches a terminal state
+CREATE TABLE IF NOT EXISTS job_webhooks (
+    id SERIAL PRIMARY KEY,
+    job_name TEXT NOT NULL,
+    url TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (job_name, url)
+);
+
+-- Signed webhook requests, retried with backoff by the coordinator until delivered or failed
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id SERIAL PRIMARY KEY,
+    job_id INT REFERENCES jobs (id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT DEFAULT 'pending',
+    attempts INT DEFAULT 0,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_status_code INT,
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_job_id ON webhook_deliveries (job_id);
+
+-- Jobs that exhausted their retries, kept until purged so they can be inspected and replayed
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id SERIAL PRIMARY KEY,
+    job_id INT NOT NULL REFERENCES jobs (id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    payload TEXT,
+    priority TEXT,
+    reason TEXT NOT NULL,
+    last_error TEXT,
+    attempts INT,
+    worker_url TEXT,
+    failed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    replayed_at TIMESTAMP,
+    replay_count INT DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_dead_letters_name_id ON dead_letters (name, id);
+CREATE INDEX IF NOT EXISTS idx_dead_letters_job_id ON dead_letters (job_id);
+
+-- Where dead letters are escalated, job_name NULL matches every job
+CREATE TABLE IF NOT EXISTS escalation_rules (
+    id SERIAL PRIMARY KEY,
+    job_name TEXT,
+    sink TEXT NOT NULL,
+    target TEXT NOT NULL,
+    group_window_seconds INT DEFAULT 300,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Identical dead letters counted per rule while their group window is open
+CREATE TABLE IF NOT EXISTS escalation_groups (
+    rule_id INT NOT NULL REFERENCES escalation_rules (id) ON DELETE CASCADE,
+    group_key TEXT NOT NULL,
+    window_started_at TIMESTAMP NOT NULL,
+    occurrences INT DEFAULT 1,
+    reported INT DEFAULT 0,
+    sample TEXT,
+    PRIMARY KEY (rule_id, group_key)
+);
+
+-- Per job name limits enforced by the coordinator when leasing, NULL means unlimited
+CREATE TABLE IF NOT EXISTS job_limits (
+    name TEXT PRIMARY KEY,
+    max_concurrent INT,
+    max_starts_per_second DOUBLE PRECISION,
+    next_start_at TIMESTAMP
+);
+
+-- Queue messages written in the same transaction as their job, pushed to Redis by the submitter's relay
+CREATE TABLE IF NOT EXISTS job_outbox (
+    id SERIAL PRIMARY KEY,
+    job_id INT NOT NULL REFERENCES jobs (id) ON DELETE CASCADE,
+    queue TEXT NOT NULL,
+    message TEXT NOT NULL,
+    attempts INT DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 CREATE TABLE IF NOT EXISTS workers (
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// BatchRequest submits jobs whose completion is tracked as a whole. The finalizer job
+// is created and the callback is called once every job has reached a terminal state
+type BatchRequest struct {
+	Name        string `json:"name"`
+	Jobs        []Job  `json:"jobs"`
+	Finalizer   *Job   `json:"finalizer"`
+	CallbackUrl string `json:"callback_url"`
+}
+
+// BatchStatus is the aggregate progress returned by GET /batches/{id}
+type BatchStatus struct {
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	Total          int        `json:"total"`
+	Completed      int        `json:"completed"`
+	Failed         int        `json:"failed"`
+	Cancelled      int        `json:"cancelled"`
+	Remaining      int        `json:"remaining"`
+	Finished       bool       `json:"finished"`
+	CreatedAt      time.Time  `json:"created_at"`
+	FinalizedAt    *time.Time `json:"finalized_at"`
+	FinalizerJobID *int       `json:"finalizer_job_id"`
+	CallbackUrl    *string    `json:"callback_url"`
+}
+
+func createBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var batch BatchRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateBatch(&batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var finalizerName, finalizerPayload, finalizerPriority, callbackUrl *string
+	if batch.Finalizer != nil {
+		finalizerName, finalizerPayload, finalizerPriority = &batch.Finalizer.Name, &batch.Finalizer.Payload, &batch.Finalizer.Priority
+	}
+	if batch.CallbackUrl != "" {
+		callbackUrl = &batch.CallbackUrl
+	}
+
+	// The batch, its jobs and their outbox entries are written in one transaction
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to create batch", http.StatusInternalServerError)
+		fmt.Println("Error starting transaction", err)
+		return
+	}
+
+	defer tx.Rollback()
+
+	var batchID int
+	err = tx.QueryRow(
+		`INSERT INTO batches (name, finalizer_job_name, finalizer_payload, finalizer_priority, callback_url)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`,
+		batch.Name, finalizerName, finalizerPayload, finalizerPriority, callbackUrl,
+	).Scan(&batchID)
+
+	if err != nil {
+		http.Error(w, "Failed to create batch", http.StatusInternalServerError)
+		fmt.Println("Error inserting batch", err)
+		return
+	}
+
+	jobIDs := make([]int, len(batch.Jobs))
+	created := make([]CreatedJob, len(batch.Jobs))
+
+	for i, job := range batch.Jobs {
+		created[i], err = insertJob(tx, job, initialStatus(job), JobGroup{BatchID: &batchID})
+		if err != nil {
+			http.Error(w, "Failed to create batch", http.StatusInternalServerError)
+			fmt.Println("Error inserting batch job", err)
+			return
+		}
+		jobIDs[i] = created[i].ID
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create batch", http.StatusInternalServerError)
+		fmt.Println("Error committing batch", err)
+		return
+	}
+
+	fmt.Println("Created batch", batch.Name, "with id", batchID, "and", len(jobIDs), "jobs")
+	publishJobs(created)
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":   batchID,
+		"jobs": jobIDs,
+	})
+}
+
+func validateBatch(batch *BatchRequest) error {
+	if batch.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(batch.Jobs) == 0 {
+		return fmt.Errorf("jobs must not be empty")
+	}
+
+	for i := range batch.Jobs {
+		job := &batch.Jobs[i]
+		if err := applyJobDefaults(job); err != nil {
+			return fmt.Errorf("job %d: %v", i, err)
+		}
+		if job.IdempotencyKey != nil || job.UniqueKey != nil {
+			return fmt.Errorf("job %d: idempotency_key and unique_key are not supported in batches", i)
+		}
+	}
+
+	if batch.Finalizer != nil {
+		if batch.Finalizer.Name == "" {
+			return fmt.Errorf("finalizer name is required")
+		}
+		if batch.Finalizer.Priority == "" {
+			batch.Finalizer.Priority = DEFAULT_PRIORITY
+		}
+		if !priorities[batch.Finalizer.Priority] {
+			return fmt.Errorf("finalizer priority must be one of high, normal or low")
+		}
+	}
+
+	if batch.CallbackUrl != "" {
+		if err := validateCallbackUrl(batch.CallbackUrl); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getBatchHandler(w http.ResponseWriter, r *http.Request) {
+	batchID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid batch id", http.StatusBadRequest)
+		return
+	}
+
+	var batch BatchStatus
+	err = db.QueryRow(`
+		SELECT b.id, b.name, b.created_at, b.finalized_at, b.finalizer_job_id, b.callback_url,
+			COUNT(j.id),
+			COUNT(j.id) FILTER (WHERE j.status = 'completed'),
+			COUNT(j.id) FILTER (WHERE j.status IN ('failed', 'upstream_failed')),
+			COUNT(j.id) FILTER (WHERE j.status = 'cancelled')
+		FROM batches b
+		LEFT JOIN jobs j ON j.batch_id = b.id
+		WHERE b.id = $1
+		GROUP BY b.id
+	`, batchID).Scan(
+		&batch.ID, &batch.Name, &batch.CreatedAt, &batch.FinalizedAt, &batch.FinalizerJobID, &batch.CallbackUrl,
+		&batch.Total, &batch.Completed, &batch.Failed, &batch.Cancelled,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Batch not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to fetch batch", http.StatusInternalServerError)
+		fmt.Println("Error fetching batch", batchID, err)
+		return
+	}
+
+	batch.Remaining = batch.Total - batch.Completed - batch.Failed - batch.Cancelled
+	batch.Finished = batch.FinalizedAt != nil
+
+	writeJSON(w, http.StatusOK, batch)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Every job submitted through the submitter, alone or as part of a workflow or batch, is
+// written by insertJob inside the caller's transaction and published by publishJobs once
+// that transaction has committed
+
+// JobGroup is the workflow or batch a job is inserted into, left empty for a single job
+type JobGroup struct {
+	WorkflowID   *int
+	WorkflowNode *string
+	BatchID      *int
+}
+
+// CreatedJob is an inserted job waiting to be published
+type CreatedJob struct {
+	ID       int
+	Name     string
+	Status   string
+	RunAt    *time.Time
+	OutboxID int
+}
+
+func initialStatus(job Job) string {
+	// Jobs due in the future wait in the scheduled set until the coordinator releases them
+	if job.RunAt != nil && job.RunAt.After(time.Now()) {
+		return "scheduled"
+	}
+	return "pending"
+}
+
+func insertJob(tx *sql.Tx, job Job, status string, group JobGroup) (CreatedJob, error) {
+	// A pending job gets its outbox entry in the same transaction, so it is never stored
+	// without also being on its way to the queue. An idempotency key already in use
+	// inserts nothing and returns sql.ErrNoRows
+	created := CreatedJob{Name: job.Name, Status: status, RunAt: job.RunAt}
+
+	err := tx.QueryRow(
+		`INSERT INTO jobs (name, payload, priority, status, run_at, max_retries, lease_timeout_seconds, backoff_policy, backoff_base_seconds, backoff_max_seconds, idempotency_key, unique_key, concurrency_key, concurrency_limit, callback_url, workflow_id, workflow_node, batch_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		ON CONFLICT (idempotency_key) DO NOTHING
+		RETURNING id`,
+		job.Name, job.Payload, job.Priority, status, job.RunAt, *job.MaxRetries, *job.LeaseTimeoutSeconds, job.Backoff, *job.BackoffBaseSeconds, *job.BackoffMaxSeconds, job.IdempotencyKey, job.UniqueKey, job.ConcurrencyKey, *job.ConcurrencyLimit, job.CallbackUrl,
+		group.WorkflowID, group.WorkflowNode, group.BatchID,
+	).Scan(&created.ID)
+
+	if err != nil {
+		return created, err
+	}
+
+	if status == "pending" {
+		created.OutboxID, err = addToOutbox(tx, created.ID, job)
+		if err != nil {
+			return created, fmt.Errorf("adding job to outbox: %v", err)
+		}
+	}
+
+	return created, nil
+}
+
+func publishJobs(jobs []CreatedJob) {
+	// Called after the commit. Nothing here is required for the jobs to run: the
+	// coordinator resyncs the scheduled set from run_at and the outbox relay retries
+	// entries that could not be pushed to Redis right away
+	for _, job := range jobs {
+		publishJobEvent("submitted", job.ID, job.Name)
+
+		switch job.Status {
+		case "scheduled":
+			err := redisClient.ZAdd("scheduled_jobs", redis.Z{
+				Score:  float64(job.RunAt.Unix()),
+				Member: fmt.Sprintf("%d", job.ID),
+			}).Err()
+
+			if err != nil {
+				fmt.Println("Error scheduling job in redis:", err)
+			}
+		case "pending":
+			relayOutbox(job.OutboxID)
+		}
+	}
+}
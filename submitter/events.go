@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Lifecycle events are published on this Redis channel, the coordinator streams them
+// to clients of its GET /events endpoint and waiting requests listen for them
+const EVENTS_CHANNEL = "job_events"
+
+func publishJobEvent(event string, jobID int, name string) {
+	// Best effort, a lost event is not retried
+	eventJson, _ := json.Marshal(map[string]interface{}{
+		"type":   "job",
+		"event":  event,
+		"job_id": fmt.Sprintf("%d", jobID),
+		"name":   name,
+		"time":   time.Now().UTC(),
+	})
+
+	if err := redisClient.Publish(EVENTS_CHANNEL, eventJson).Err(); err != nil {
+		fmt.Println("Error publishing event:", err)
+	}
+}
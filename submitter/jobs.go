@@ -0,0 +1,386 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// JobRecord mirrors a row of the jobs table, nullable columns are pointers
+type JobRecord struct {
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	Payload        *string    `json:"payload"`
+	Priority       string     `json:"priority"`
+	Status         string     `json:"status"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RunAt          *time.Time `json:"run_at"`
+	LeaseStart     *time.Time `json:"lease_start"`
+	LeaseRenewedAt *time.Time `json:"lease_renewed_at"`
+	LeaseTimeout   *int       `json:"lease_timeout"`
+	LeasedToWorker *string    `json:"leased_to_worker"`
+	LeaseToken     int        `json:"lease_token"`
+	CompletedAt    *time.Time `json:"completed_at"`
+	Retries        int        `json:"retries"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at"`
+	MaxRetries     int        `json:"max_retries"`
+	Result         *string    `json:"result"`
+
+	LeaseTimeoutSeconds int     `json:"lease_timeout_seconds"`
+	Backoff             string  `json:"backoff_policy"`
+	BackoffBaseSeconds  int     `json:"backoff_base_seconds"`
+	BackoffMaxSeconds   int     `json:"backoff_max_seconds"`
+	IdempotencyKey      *string `json:"idempotency_key"`
+	UniqueKey           *string `json:"unique_key"`
+	ConcurrencyKey      *string `json:"concurrency_key"`
+	ConcurrencyLimit    int     `json:"concurrency_limit"`
+	WorkflowID          *int    `json:"workflow_id"`
+	BatchID             *int    `json:"batch_id"`
+	CallbackUrl         *string `json:"callback_url"`
+}
+
+// JobAttempt is one lease of a job, a row of the job_attempts table
+type JobAttempt struct {
+	Attempt         int        `json:"attempt"`
+	WorkerUrl       *string    `json:"worker_url"`
+	StartedAt       time.Time  `json:"started_at"`
+	FinishedAt      *time.Time `json:"finished_at"`
+	Outcome         string     `json:"outcome"` // running, completed, failed, timed_out, cancelled or requeued
+	Error           *string    `json:"error"`
+	DurationSeconds *float64   `json:"duration_seconds"`
+}
+
+// JobEventRecord is one status transition of a job, a row of the job_events table.
+// FromStatus is nil for the event logged when the job was created
+type JobEventRecord struct {
+	FromStatus *string   `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Column list shared by every query that scans into a JobRecord
+const jobColumns = `id, name, payload, priority, status, created_at, run_at, lease_start, lease_renewed_at, lease_timeout,
+	leased_to_worker, lease_token, completed_at, retries, next_attempt_at, max_retries, result,
+	lease_timeout_seconds, backoff_policy, backoff_base_seconds, backoff_max_seconds, idempotency_key, unique_key,
+	concurrency_key, concurrency_limit, workflow_id, batch_id, callback_url`
+
+// Job listing page size limits
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// Statuses accepted by the listing filter
+var jobStatuses = map[string]bool{
+	"pending":   true,
+	"leased":    true,
+	"completed": true,
+	"failed":    true,
+	"cancelled": true,
+	"scheduled": true,
+	"queued":    true,
+	"blocked":   true,
+
+	"upstream_failed": true,
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row rowScanner) (JobRecord, error) {
+	var job JobRecord
+	err := row.Scan(
+		&job.ID, &job.Name, &job.Payload, &job.Priority, &job.Status, &job.CreatedAt, &job.RunAt, &job.LeaseStart, &job.LeaseRenewedAt, &job.LeaseTimeout,
+		&job.LeasedToWorker, &job.LeaseToken, &job.CompletedAt, &job.Retries, &job.NextAttemptAt, &job.MaxRetries, &job.Result,
+		&job.LeaseTimeoutSeconds, &job.Backoff, &job.BackoffBaseSeconds, &job.BackoffMaxSeconds, &job.IdempotencyKey, &job.UniqueKey,
+		&job.ConcurrencyKey, &job.ConcurrencyLimit, &job.WorkflowID, &job.BatchID, &job.CallbackUrl,
+	)
+	return job, err
+}
+
+func getJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	job, err := scanJob(db.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE id = $1", jobID))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to fetch job", http.StatusInternalServerError)
+		fmt.Println("Error fetching job", jobID, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+func listJobAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM jobs WHERE id = $1)", jobID).Scan(&exists); err != nil {
+		http.Error(w, "Failed to fetch job attempts", http.StatusInternalServerError)
+		fmt.Println("Error fetching job", jobID, err)
+		return
+	}
+	if !exists {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT attempt, worker_url, started_at, finished_at, outcome, error, duration_seconds
+		FROM job_attempts WHERE job_id = $1
+		ORDER BY attempt
+	`, jobID)
+
+	if err != nil {
+		http.Error(w, "Failed to fetch job attempts", http.StatusInternalServerError)
+		fmt.Println("Error fetching attempts for job", jobID, err)
+		return
+	}
+	defer rows.Close()
+
+	attempts := []JobAttempt{}
+	for rows.Next() {
+		var a JobAttempt
+		if err := rows.Scan(&a.Attempt, &a.WorkerUrl, &a.StartedAt, &a.FinishedAt, &a.Outcome, &a.Error, &a.DurationSeconds); err != nil {
+			http.Error(w, "Failed to fetch job attempts", http.StatusInternalServerError)
+			fmt.Println("Error scanning attempt row:", err)
+			return
+		}
+		attempts = append(attempts, a)
+	}
+
+	writeJSON(w, http.StatusOK, attempts)
+}
+
+func listJobEventsHandler(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM jobs WHERE id = $1)", jobID).Scan(&exists); err != nil {
+		http.Error(w, "Failed to fetch job events", http.StatusInternalServerError)
+		fmt.Println("Error fetching job", jobID, err)
+		return
+	}
+	if !exists {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := db.Query(
+		"SELECT from_status, to_status, reason, created_at FROM job_events WHERE job_id = $1 ORDER BY id", jobID,
+	)
+
+	if err != nil {
+		http.Error(w, "Failed to fetch job events", http.StatusInternalServerError)
+		fmt.Println("Error fetching events for job", jobID, err)
+		return
+	}
+	defer rows.Close()
+
+	events := []JobEventRecord{}
+	for rows.Next() {
+		var e JobEventRecord
+		if err := rows.Scan(&e.FromStatus, &e.ToStatus, &e.Reason, &e.CreatedAt); err != nil {
+			http.Error(w, "Failed to fetch job events", http.StatusInternalServerError)
+			fmt.Println("Error scanning job event row:", err)
+			return
+		}
+		events = append(events, e)
+	}
+
+	writeJSON(w, http.StatusOK, events)
+}
+
+func cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	// Job statuses are only changed by the coordinator's state machine, the cancel is
+	// forwarded to it and its answer relayed as is. The coordinator also stops a leased
+	// job on its worker and moves on the job's workflow and batch
+	resp, err := coordinatorClient.Post(fmt.Sprintf("%s/jobs/%d/cancel", coordinatorUrl, jobID), "application/json", nil)
+	if err != nil {
+		http.Error(w, "Failed to cancel job", http.StatusBadGateway)
+		fmt.Println("Error forwarding cancel of job", jobID, "to the coordinator:", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// Build the WHERE clause from the supplied filters
+	var conditions []string
+	var args []interface{}
+
+	addCondition := func(clause string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(clause, len(args)))
+	}
+
+	if status := query.Get("status"); status != "" {
+		if !jobStatuses[status] {
+			http.Error(w, "Invalid status filter", http.StatusBadRequest)
+			return
+		}
+		addCondition("status = $%d", status)
+	}
+
+	if name := query.Get("name"); name != "" {
+		addCondition("name = $%d", name)
+	}
+
+	if worker := query.Get("worker"); worker != "" {
+		addCondition("leased_to_worker = $%d", worker)
+	}
+
+	for param, clause := range map[string]string{
+		"created_after":  "created_at >= $%d",
+		"created_before": "created_at < $%d",
+	} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		createdAt, err := parseCreatedFilter(value)
+		if err != nil {
+			http.Error(w, "Invalid "+param+", expected RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		addCondition(clause, createdAt)
+	}
+
+	// Cursor is the id of the last job on the previous page, results are newest first
+	if cursor := query.Get("cursor"); cursor != "" {
+		cursorID, err := strconv.Atoi(cursor)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		addCondition("id < $%d", cursorID)
+	}
+
+	limit := defaultPageSize
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxPageSize)
+	}
+
+	sqlQuery := "SELECT " + jobColumns + " FROM jobs"
+	if len(conditions) > 0 {
+		sqlQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// Fetch one extra row to know whether another page exists
+	args = append(args, limit+1)
+	sqlQuery += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := db.Query(sqlQuery, args...)
+	if err != nil {
+		http.Error(w, "Failed to list jobs", http.StatusInternalServerError)
+		fmt.Println("Error listing jobs:", err)
+		return
+	}
+	defer rows.Close()
+
+	jobs := []JobRecord{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			http.Error(w, "Failed to list jobs", http.StatusInternalServerError)
+			fmt.Println("Error scanning job row:", err)
+			return
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Failed to list jobs", http.StatusInternalServerError)
+		fmt.Println("Error iterating job rows:", err)
+		return
+	}
+
+	var nextCursor *int
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+		nextCursor = &jobs[limit-1].ID
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"jobs":        jobs,
+		"next_cursor": nextCursor,
+	})
+}
+
+func parseCreatedFilter(value string) (time.Time, error) {
+	// created_at is a timestamp without time zone holding UTC, the driver would send the
+	// offset along and Postgres would drop it, so the instant is converted to UTC first
+	createdAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return createdAt.UTC(), nil
+}
+
+func validateCallbackUrl(value string) error {
+	if !webhooksEnabled {
+		return fmt.Errorf("callback_url cannot be used, WEBHOOK_SECRET is not set")
+	}
+	if !isHttpUrl(value) {
+		return fmt.Errorf("callback_url must be an http or https URL")
+	}
+	return nil
+}
+
+func isHttpUrl(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		fmt.Println("Error encoding response:", err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCreatedFilter(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2026-01-01T00:00:00Z", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"2026-01-01T00:00:00-05:00", time.Date(2026, 1, 1, 5, 0, 0, 0, time.UTC), false},
+		{"2026-01-01T02:30:00+05:30", time.Date(2025, 12, 31, 21, 0, 0, 0, time.UTC), false},
+		{"2026-01-01", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCreatedFilter(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCreatedFilter(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		// The wall clock is what reaches a timestamp without time zone column
+		if got.Location() != time.UTC || !got.Equal(tt.want) || got.Hour() != tt.want.Hour() {
+			t.Errorf("parseCreatedFilter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidateCallbackUrl(t *testing.T) {
+	defer func(enabled bool) { webhooksEnabled = enabled }(webhooksEnabled)
+
+	webhooksEnabled = false
+	if err := validateCallbackUrl("http://example.com/done"); err == nil {
+		t.Error("callback_url accepted without a webhook secret")
+	}
+
+	webhooksEnabled = true
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"http://example.com/done", false},
+		{"https://example.com/done", false},
+		{"ftp://example.com/done", true},
+		{"example.com/done", true},
+	}
+	for _, tt := range tests {
+		if err := validateCallbackUrl(tt.value); (err != nil) != tt.wantErr {
+			t.Errorf("validateCallbackUrl(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -16,13 +17,72 @@ import (
 )
 
 type Job struct {
-	Name    string `json:"name"`
-	Payload string `json:"payload"`
+	Name                string `json:"name"`
+	Payload             string `json:"payload"`
+	Priority            string `json:"priority"`
+	MaxRetries          *int   `json:"max_retries"`
+	LeaseTimeoutSeconds *int   `json:"lease_timeout_seconds"`
+	Backoff             string `json:"backoff_policy"`
+	BackoffBaseSeconds  *int   `json:"backoff_base_seconds"`
+	BackoffMaxSeconds   *int   `json:"backoff_max_seconds"`
+
+	// Optional delayed start, either an absolute time or a delay from now
+	RunAt        *time.Time `json:"run_at"`
+	DelaySeconds *int       `json:"delay_seconds"`
+
+	// Optional client supplied key, repeated submissions with it return the original job
+	IdempotencyKey *string `json:"idempotency_key"`
+
+	// Optional business key, at most one job per name and key is active at a time
+	UniqueKey    *string `json:"unique_key"`
+	UniquePolicy string  `json:"unique_policy"`
+
+	// Optional mutual exclusion, at most concurrency_limit jobs sharing the key are leased at once
+	ConcurrencyKey   *string `json:"concurrency_key"`
+	ConcurrencyLimit *int    `json:"concurrency_limit"`
+
+	// Optional URL the coordinator POSTs a signed event to once the job completes or fails
+	CallbackUrl *string `json:"callback_url"`
+}
+
+// Defaults applied when a submission leaves the retry settings out
+const (
+	DEFAULT_MAX_RETRIES           = 3
+	DEFAULT_LEASE_TIMEOUT_SECONDS = 20
+	DEFAULT_BACKOFF_POLICY        = "exponential"
+	DEFAULT_BACKOFF_BASE_SECONDS  = 1
+	DEFAULT_BACKOFF_MAX_SECONDS   = 60
+	DEFAULT_PRIORITY              = "normal"
+	DEFAULT_CONCURRENCY_LIMIT     = 1
+
+	// How long an idempotency key maps to its original job
+	IDEMPOTENCY_KEY_RETENTION = 24 * time.Hour
+)
+
+// Priority lanes, each backed by its own Redis list
+var priorities = map[string]bool{
+	"high":   true,
+	"normal": true,
+	"low":    true,
+}
+
+var backoffPolicies = map[string]bool{
+	"exponential": true,
+	"linear":      true,
+	"fixed":       true,
 }
 
 var db *sql.DB
 var redisClient *redis.Client
 
+// Whether the coordinator signs webhooks, callback URLs are refused when it cannot. Only
+// the presence of WEBHOOK_SECRET matters here, requests are signed by the coordinator
+var webhooksEnabled bool
+
+// The coordinator owns job status changes, requests that change a status are sent to it
+var coordinatorUrl string
+var coordinatorClient = &http.Client{Timeout: 10 * time.Second}
+
 func main() {
 	dbUrl := os.Getenv("DATABASE_URL")
 
@@ -69,9 +129,30 @@ func main() {
 		panic("Could not connect to Redis: " + err.Error())
 	}
 
+	webhooksEnabled = os.Getenv("WEBHOOK_SECRET") != ""
+
+	coordinatorUrl = strings.TrimSuffix(os.Getenv("COORDINATOR_URL"), "/")
+
+	if coordinatorUrl == "" {
+		panic("Coordinator URL not found")
+	}
+
+	// Outbox Relay
+	go outboxRelay()
+
 	// Set HTTP Server
 	r := mux.NewRouter()
 	r.HandleFunc("/submit_job", createJobHandler).Methods("POST")
+	r.HandleFunc("/jobs", listJobsHandler).Methods("GET")
+	r.HandleFunc("/jobs/{id}", getJobHandler).Methods("GET")
+	r.HandleFunc("/jobs/{id}/cancel", cancelJobHandler).Methods("POST")
+	r.HandleFunc("/jobs/{id}/wait", waitJobHandler).Methods("GET")
+	r.HandleFunc("/jobs/{id}/attempts", listJobAttemptsHandler).Methods("GET")
+	r.HandleFunc("/jobs/{id}/events", listJobEventsHandler).Methods("GET")
+	r.HandleFunc("/workflows", createWorkflowHandler).Methods("POST")
+	r.HandleFunc("/workflows/{id}", getWorkflowHandler).Methods("GET")
+	r.HandleFunc("/batches", createBatchHandler).Methods("POST")
+	r.HandleFunc("/batches/{id}", getBatchHandler).Methods("GET")
 
 	fmt.Println("Scheduler service is running on :8000")
 	log.Fatal(http.ListenAndServe(":8000", r))
@@ -85,13 +166,89 @@ func createJobHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Insert the job info into the database and get job_id
-	var jobID int
+	// The key may come from the Idempotency-Key header or the request body
+	if headerKey := r.Header.Get("Idempotency-Key"); headerKey != "" {
+		if job.IdempotencyKey != nil && *job.IdempotencyKey != headerKey {
+			http.Error(w, "Idempotency-Key header and idempotency_key field differ", http.StatusBadRequest)
+			return
+		}
+		job.IdempotencyKey = &headerKey
+	}
 
-	err := db.QueryRow(
-		"INSERT INTO jobs (name, payload) VALUES ($1, $2) RETURNING id",
-		job.Name, job.Payload,
-	).Scan(&jobID)
+	if err := applyJobDefaults(&job); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// With ?wait=30s the response is held until the job finishes, see writeJobResult
+	wait, err := parseWait(r.URL.Query().Get("wait"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := initialStatus(job)
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to create job", http.StatusInternalServerError)
+		fmt.Println("Error starting transaction", err)
+		return
+	}
+
+	defer tx.Rollback()
+
+	if job.IdempotencyKey != nil {
+		// Release the key from a job outside the retention window so it can be reused
+		_, err = tx.Exec(
+			"UPDATE jobs SET idempotency_key = NULL WHERE idempotency_key = $1 AND created_at < $2",
+			*job.IdempotencyKey, time.Now().UTC().Add(-IDEMPOTENCY_KEY_RETENTION),
+		)
+		if err != nil {
+			http.Error(w, "Failed to create job", http.StatusInternalServerError)
+			fmt.Println("Error expiring idempotency key", err)
+			return
+		}
+	}
+
+	if job.UniqueKey != nil {
+		// Another active job with the same business key may absorb this submission
+		mergedID, mergedStatus, newStatus, err := resolveUniqueJob(tx, job, status)
+		if err != nil {
+			http.Error(w, "Failed to create job", http.StatusInternalServerError)
+			fmt.Println("Error resolving unique key", err)
+			return
+		}
+
+		if mergedID != 0 {
+			if err := tx.Commit(); err != nil {
+				http.Error(w, "Failed to create job", http.StatusInternalServerError)
+				fmt.Println("Error committing job", err)
+				return
+			}
+
+			if wait > 0 {
+				writeJobResult(w, r, mergedID, wait)
+				return
+			}
+
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"id":            mergedID,
+				"status":        mergedStatus,
+				"unique_policy": job.UniquePolicy,
+			})
+			return
+		}
+		status = newStatus
+	}
+
+	// A key already in use inserts nothing and the original job is returned instead
+	created, err := insertJob(tx, job, status, JobGroup{})
+
+	if err == sql.ErrNoRows {
+		replayIdempotentSubmission(w, r, *job.IdempotencyKey, wait)
+		return
+	}
 
 	if err != nil {
 		http.Error(w, "Failed to create job", http.StatusInternalServerError)
@@ -99,22 +256,138 @@ func createJobHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte("Job created successfully"))
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create job", http.StatusInternalServerError)
+		fmt.Println("Error committing job", err)
+		return
+	}
 
-	fmt.Println("Created job", job.Name, "with id", jobID)
+	fmt.Println("Created job", job.Name, "with id", created.ID)
+	publishJobs([]CreatedJob{created})
 
-	// Insert job to Redis queue
-	jobWithID := map[string]interface{}{
-		"id":      fmt.Sprintf("%d", jobID),
-		"name":    job.Name,
-		"payload": job.Payload,
+	if wait > 0 {
+		writeJobResult(w, r, created.ID, wait)
+		return
 	}
 
-	jobJson, _ := json.Marshal(jobWithID)
-	err = redisClient.LPush("job_queue", jobJson).Err()
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":     created.ID,
+		"status": status,
+	})
+}
+
+func replayIdempotentSubmission(w http.ResponseWriter, r *http.Request, idempotencyKey string, wait time.Duration) {
+	// Answer a repeated submission with the job created by the first one
+	var jobID int
+	var status string
+
+	err := db.QueryRow(
+		"SELECT id, status FROM jobs WHERE idempotency_key = $1", idempotencyKey,
+	).Scan(&jobID, &status)
 
 	if err != nil {
-		fmt.Println("Error pushing job to redis:", err)
+		http.Error(w, "Failed to create job", http.StatusInternalServerError)
+		fmt.Println("Error fetching job for idempotency key", idempotencyKey, err)
+		return
 	}
+
+	fmt.Println("Idempotency key", idempotencyKey, "already used by job", jobID)
+
+	w.Header().Set("Idempotent-Replayed", "true")
+
+	if wait > 0 {
+		writeJobResult(w, r, jobID, wait)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     jobID,
+		"status": status,
+	})
+}
+
+func applyJobDefaults(job *Job) error {
+	// Fill in unset retry settings and validate the supplied ones
+	defaultInt := func(value **int, fallback int) {
+		if *value == nil {
+			*value = &fallback
+		}
+	}
+
+	defaultInt(&job.MaxRetries, DEFAULT_MAX_RETRIES)
+	defaultInt(&job.LeaseTimeoutSeconds, DEFAULT_LEASE_TIMEOUT_SECONDS)
+	defaultInt(&job.BackoffBaseSeconds, DEFAULT_BACKOFF_BASE_SECONDS)
+	defaultInt(&job.BackoffMaxSeconds, DEFAULT_BACKOFF_MAX_SECONDS)
+	defaultInt(&job.ConcurrencyLimit, DEFAULT_CONCURRENCY_LIMIT)
+
+	if job.Backoff == "" {
+		job.Backoff = DEFAULT_BACKOFF_POLICY
+	}
+	if job.Priority == "" {
+		job.Priority = DEFAULT_PRIORITY
+	}
+
+	if job.DelaySeconds != nil {
+		if job.RunAt != nil {
+			return fmt.Errorf("only one of run_at or delay_seconds may be set")
+		}
+		if *job.DelaySeconds < 0 {
+			return fmt.Errorf("delay_seconds must not be negative")
+		}
+		runAt := time.Now().Add(time.Duration(*job.DelaySeconds) * time.Second)
+		job.RunAt = &runAt
+	}
+
+	if job.UniqueKey != nil {
+		if job.UniquePolicy == "" {
+			job.UniquePolicy = DEFAULT_UNIQUE_POLICY
+		}
+		if !uniquePolicies[job.UniquePolicy] {
+			return fmt.Errorf("unique_policy must be one of drop, replace or queue_after")
+		}
+		// Queued jobs start as soon as the active one finishes
+		if job.UniquePolicy != "drop" && job.RunAt != nil {
+			return fmt.Errorf("unique_policy %s cannot be combined with run_at or delay_seconds", job.UniquePolicy)
+		}
+	} else if job.UniquePolicy != "" {
+		return fmt.Errorf("unique_policy requires unique_key")
+	}
+
+	// run_at is stored in a TIMESTAMP column, keep it in UTC
+	if job.RunAt != nil {
+		runAt := job.RunAt.UTC()
+		job.RunAt = &runAt
+	}
+
+	if job.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !priorities[job.Priority] {
+		return fmt.Errorf("priority must be one of high, normal or low")
+	}
+	if *job.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative")
+	}
+	if *job.LeaseTimeoutSeconds <= 0 {
+		return fmt.Errorf("lease_timeout_seconds must be positive")
+	}
+	if !backoffPolicies[job.Backoff] {
+		return fmt.Errorf("backoff_policy must be one of exponential, linear or fixed")
+	}
+	if *job.BackoffBaseSeconds < 0 || *job.BackoffMaxSeconds < *job.BackoffBaseSeconds {
+		return fmt.Errorf("backoff_base_seconds must not be negative or exceed backoff_max_seconds")
+	}
+	if job.ConcurrencyKey != nil && *job.ConcurrencyKey == "" {
+		return fmt.Errorf("concurrency_key must not be empty")
+	}
+	if *job.ConcurrencyLimit <= 0 {
+		return fmt.Errorf("concurrency_limit must be positive")
+	}
+	if job.CallbackUrl != nil {
+		if err := validateCallbackUrl(*job.CallbackUrl); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Upper bound on outbox entries published in a single relay pass
+const OUTBOX_BATCH_SIZE = 100
+
+func addToOutbox(tx *sql.Tx, jobID int, job Job) (int, error) {
+	// Store the queue message next to the job, it is pushed to Redis by the relay
+	jobWithID := map[string]interface{}{
+		"id":       fmt.Sprintf("%d", jobID),
+		"name":     job.Name,
+		"payload":  job.Payload,
+		"priority": job.Priority,
+	}
+
+	jobJson, _ := json.Marshal(jobWithID)
+
+	var outboxID int
+	err := tx.QueryRow(
+		"INSERT INTO job_outbox (job_id, queue, message) VALUES ($1, $2, $3) RETURNING id",
+		jobID, "job_queue:"+job.Priority, string(jobJson),
+	).Scan(&outboxID)
+
+	return outboxID, err
+}
+
+func outboxRelay() {
+	for {
+		published, err := relayOutbox(0)
+		if err != nil {
+			fmt.Println("Error relaying outbox:", err)
+		}
+
+		// A full pass means more entries are waiting, relay them without waiting
+		if published < OUTBOX_BATCH_SIZE {
+			time.Sleep(1 * time.Second)
+		}
+	}
+}
+
+func relayOutbox(outboxID int) (int, error) {
+	// Push outbox entries to Redis and delete them once pushed. An outboxID of 0 relays
+	// the oldest entries, otherwise only that entry. SKIP LOCKED keeps concurrent
+	// relays from publishing the same entry
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, queue, message FROM job_outbox
+		WHERE $1 = 0 OR id = $1
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, outboxID, OUTBOX_BATCH_SIZE)
+
+	if err != nil {
+		return 0, err
+	}
+
+	type outboxEntry struct {
+		ID      int
+		Queue   string
+		Message string
+	}
+
+	var entries []outboxEntry
+	for rows.Next() {
+		var entry outboxEntry
+		if err := rows.Scan(&entry.ID, &entry.Queue, &entry.Message); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		entries = append(entries, entry)
+	}
+
+	rows.Close()
+
+	published := 0
+	for _, entry := range entries {
+		if err := redisClient.LPush(entry.Queue, entry.Message).Err(); err != nil {
+			// Keep the entry for the next pass and record why it failed
+			fmt.Println("Error pushing outbox entry", entry.ID, "to redis:", err)
+			_, err = tx.Exec(
+				"UPDATE job_outbox SET attempts = attempts + 1, last_error = $1 WHERE id = $2",
+				err.Error(), entry.ID,
+			)
+			if err != nil {
+				return published, err
+			}
+			continue
+		}
+
+		// A crash between the push and the commit publishes the entry twice, the
+		// coordinator only leases pending jobs so the duplicate is skipped
+		if _, err := tx.Exec("DELETE FROM job_outbox WHERE id = $1", entry.ID); err != nil {
+			return published, err
+		}
+		published++
+	}
+
+	return published, tx.Commit()
+}
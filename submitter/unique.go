@@ -0,0 +1,64 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Policies for a submission whose name and unique_key match a job that is still active
+var uniquePolicies = map[string]bool{
+	"drop":        true, // keep the active job, discard the new one
+	"replace":     true, // overwrite the payload of the waiting job
+	"queue_after": true, // hold the new job as queued until the active one finishes
+}
+
+const DEFAULT_UNIQUE_POLICY = "drop"
+
+// resolveUniqueJob applies the job's unique policy inside the submission transaction.
+// It returns the id and status of the existing job when the submission was merged into
+// it, otherwise 0 and the status the new job should be created with
+func resolveUniqueJob(tx *sql.Tx, job Job, status string) (int, string, string, error) {
+	// Serialize submissions sharing a key, the coordinator takes the same lock when
+	// promoting queued jobs
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1 || ':' || $2))", job.Name, *job.UniqueKey)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	// Prefer a job that has not started yet, it is the one a replace can still change
+	var activeID int
+	var activeStatus string
+
+	err = tx.QueryRow(`
+		SELECT id, status FROM jobs
+		WHERE name = $1 AND unique_key = $2 AND status IN ('scheduled', 'pending', 'queued', 'leased')
+		ORDER BY status = 'leased', id
+		LIMIT 1
+	`, job.Name, *job.UniqueKey).Scan(&activeID, &activeStatus)
+
+	if err == sql.ErrNoRows {
+		return 0, "", status, nil
+	}
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	switch job.UniquePolicy {
+	case "replace":
+		if activeStatus != "leased" {
+			_, err = tx.Exec("UPDATE jobs SET payload = $1 WHERE id = $2", job.Payload, activeID)
+			if err != nil {
+				return 0, "", "", err
+			}
+			fmt.Println("Replaced payload of job", activeID, "for unique key", *job.UniqueKey)
+			return activeID, activeStatus, "", nil
+		}
+		// The active job is already running and cannot be changed, run the new payload after it
+		return 0, "", "queued", nil
+	case "queue_after":
+		return 0, "", "queued", nil
+	default:
+		fmt.Println("Dropped submission for unique key", *job.UniqueKey, "job", activeID, "is still", activeStatus)
+		return activeID, activeStatus, "", nil
+	}
+}
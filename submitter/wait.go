@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Longest a request may block waiting for a job to finish
+const MAX_WAIT = 2 * time.Minute
+
+// Wait used by GET /jobs/{id}/wait when no timeout is given
+const DEFAULT_WAIT = 30 * time.Second
+
+// Waiters re-read the job this often in case its terminal event was lost
+const WAIT_POLL_INTERVAL = 2 * time.Second
+
+// Statuses a job never leaves
+var terminalStatuses = map[string]bool{
+	"completed":       true,
+	"failed":          true,
+	"cancelled":       true,
+	"upstream_failed": true,
+}
+
+func parseWait(value string) (time.Duration, error) {
+	// Accepts a Go duration such as 30s or a plain number of seconds
+	if value == "" {
+		return 0, nil
+	}
+
+	wait, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid wait duration %q", value)
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+
+	if wait < 0 {
+		return 0, fmt.Errorf("wait duration must not be negative")
+	}
+	return min(wait, MAX_WAIT), nil
+}
+
+func waitForJob(r *http.Request, jobID int, timeout time.Duration) (JobRecord, error) {
+	// Block until the job reaches a terminal state, the timeout passes or the client
+	// goes away, and return the job as last read from Postgres
+	pubsub := redisClient.Subscribe(EVENTS_CHANNEL)
+	defer pubsub.Close()
+
+	// Subscribe before the first read, so an event fired in between is not missed
+	if _, err := pubsub.Receive(); err != nil {
+		fmt.Println("Error subscribing to events, falling back to polling:", err)
+	}
+
+	messages := pubsub.Channel()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	poll := time.NewTicker(WAIT_POLL_INTERVAL)
+	defer poll.Stop()
+
+	for {
+		job, err := scanJob(db.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE id = $1", jobID))
+		if err != nil || terminalStatuses[job.Status] {
+			return job, err
+		}
+
+	wait:
+		for {
+			select {
+			case <-r.Context().Done():
+				return job, nil
+			case <-deadline.C:
+				return job, nil
+			case <-poll.C:
+				break wait
+			case message := <-messages:
+				var event struct {
+					JobID string `json:"job_id"`
+				}
+				if json.Unmarshal([]byte(message.Payload), &event) == nil && event.JobID == strconv.Itoa(jobID) {
+					break wait
+				}
+			}
+		}
+	}
+}
+
+func writeJobResult(w http.ResponseWriter, r *http.Request, jobID int, wait time.Duration) {
+	// 200 with the finished job, or 202 with its current state if it is still running
+	job, err := waitForJob(r, jobID, wait)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to fetch job", http.StatusInternalServerError)
+		fmt.Println("Error waiting for job", jobID, err)
+		return
+	}
+
+	if !terminalStatuses[job.Status] {
+		writeJSON(w, http.StatusAccepted, job)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func waitJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	wait, err := parseWait(r.URL.Query().Get("timeout"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if wait == 0 {
+		wait = DEFAULT_WAIT
+	}
+
+	writeJobResult(w, r, jobID, wait)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// WorkflowNode is one job of a workflow, depends_on lists the keys of the jobs that
+// have to complete before it starts
+type WorkflowNode struct {
+	Job
+	Key       string   `json:"key"`
+	DependsOn []string `json:"depends_on"`
+}
+
+type WorkflowRequest struct {
+	Name string         `json:"name"`
+	Jobs []WorkflowNode `json:"jobs"`
+}
+
+// WorkflowNodeStatus is the state of one workflow job as returned by GET /workflows/{id}
+type WorkflowNodeStatus struct {
+	Key         string     `json:"key"`
+	JobID       int        `json:"job_id"`
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	DependsOn   []string   `json:"depends_on"`
+	Retries     int        `json:"retries"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+func createWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	var workflow WorkflowRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&workflow); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	order, err := validateWorkflow(&workflow)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The workflow, its jobs, their edges and the outbox entries of the root jobs are
+	// written in one transaction, a workflow is never stored half way
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to create workflow", http.StatusInternalServerError)
+		fmt.Println("Error starting transaction", err)
+		return
+	}
+
+	defer tx.Rollback()
+
+	var workflowID int
+	err = tx.QueryRow("INSERT INTO workflows (name) VALUES ($1) RETURNING id", workflow.Name).Scan(&workflowID)
+	if err != nil {
+		http.Error(w, "Failed to create workflow", http.StatusInternalServerError)
+		fmt.Println("Error inserting workflow", err)
+		return
+	}
+
+	// Parents are inserted before their children, so every edge points at a known id
+	jobIDs := make(map[string]int)
+	var created []CreatedJob
+
+	for _, node := range order {
+		status := initialStatus(node.Job)
+		if len(node.DependsOn) > 0 {
+			status = "blocked"
+		}
+
+		key := node.Key
+		job, err := insertJob(tx, node.Job, status, JobGroup{WorkflowID: &workflowID, WorkflowNode: &key})
+		if err != nil {
+			http.Error(w, "Failed to create workflow", http.StatusInternalServerError)
+			fmt.Println("Error inserting workflow job", node.Key, err)
+			return
+		}
+		created = append(created, job)
+		jobIDs[node.Key] = job.ID
+
+		for _, parent := range node.DependsOn {
+			_, err = tx.Exec(
+				"INSERT INTO job_dependencies (job_id, depends_on_job_id) VALUES ($1, $2)",
+				job.ID, jobIDs[parent],
+			)
+			if err != nil {
+				http.Error(w, "Failed to create workflow", http.StatusInternalServerError)
+				fmt.Println("Error inserting workflow dependency", node.Key, parent, err)
+				return
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create workflow", http.StatusInternalServerError)
+		fmt.Println("Error committing workflow", err)
+		return
+	}
+
+	fmt.Println("Created workflow", workflow.Name, "with id", workflowID, "and", len(order), "jobs")
+	publishJobs(created)
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":   workflowID,
+		"jobs": jobIDs,
+	})
+}
+
+func validateWorkflow(workflow *WorkflowRequest) ([]WorkflowNode, error) {
+	// Validate every node and return them in dependency order, parents first
+	if workflow.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if len(workflow.Jobs) == 0 {
+		return nil, fmt.Errorf("jobs must not be empty")
+	}
+
+	nodes := make(map[string]*WorkflowNode)
+	for i := range workflow.Jobs {
+		node := &workflow.Jobs[i]
+
+		if node.Key == "" {
+			return nil, fmt.Errorf("every job needs a key")
+		}
+		if nodes[node.Key] != nil {
+			return nil, fmt.Errorf("duplicate job key %s", node.Key)
+		}
+		if err := applyJobDefaults(&node.Job); err != nil {
+			return nil, fmt.Errorf("job %s: %v", node.Key, err)
+		}
+		if node.IdempotencyKey != nil || node.UniqueKey != nil {
+			return nil, fmt.Errorf("job %s: idempotency_key and unique_key are not supported in workflows", node.Key)
+		}
+		if node.RunAt != nil && len(node.DependsOn) > 0 {
+			return nil, fmt.Errorf("job %s: run_at and delay_seconds are only supported on jobs without depends_on", node.Key)
+		}
+		nodes[node.Key] = node
+	}
+
+	// Kahn's algorithm, whatever is left once no more nodes become ready is part of a cycle
+	remaining := make(map[string]int)
+	children := make(map[string][]string)
+	for _, node := range workflow.Jobs {
+		seen := make(map[string]bool)
+		for _, parent := range node.DependsOn {
+			if nodes[parent] == nil {
+				return nil, fmt.Errorf("job %s depends on unknown job %s", node.Key, parent)
+			}
+			if parent == node.Key {
+				return nil, fmt.Errorf("job %s depends on itself", node.Key)
+			}
+			if seen[parent] {
+				return nil, fmt.Errorf("job %s lists %s twice in depends_on", node.Key, parent)
+			}
+			seen[parent] = true
+			children[parent] = append(children[parent], node.Key)
+		}
+		remaining[node.Key] = len(node.DependsOn)
+	}
+
+	var ready []string
+	for _, node := range workflow.Jobs {
+		if remaining[node.Key] == 0 {
+			ready = append(ready, node.Key)
+		}
+	}
+
+	var order []WorkflowNode
+	for len(ready) > 0 {
+		key := ready[0]
+		ready = ready[1:]
+		order = append(order, *nodes[key])
+
+		for _, child := range children[key] {
+			remaining[child]--
+			if remaining[child] == 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+
+	if len(order) != len(workflow.Jobs) {
+		return nil, fmt.Errorf("depends_on contains a cycle")
+	}
+
+	return order, nil
+}
+
+func getWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	workflowID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid workflow id", http.StatusBadRequest)
+		return
+	}
+
+	var name string
+	var createdAt time.Time
+	err = db.QueryRow("SELECT name, created_at FROM workflows WHERE id = $1", workflowID).Scan(&name, &createdAt)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Workflow not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to fetch workflow", http.StatusInternalServerError)
+		fmt.Println("Error fetching workflow", workflowID, err)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT j.workflow_node, j.id, j.name, j.status, j.retries, j.created_at, j.completed_at,
+			COALESCE(array_agg(parent.workflow_node ORDER BY parent.id) FILTER (WHERE parent.id IS NOT NULL), '{}')
+		FROM jobs j
+		LEFT JOIN job_dependencies d ON d.job_id = j.id
+		LEFT JOIN jobs parent ON parent.id = d.depends_on_job_id
+		WHERE j.workflow_id = $1
+		GROUP BY j.id
+		ORDER BY j.id
+	`, workflowID)
+
+	if err != nil {
+		http.Error(w, "Failed to fetch workflow", http.StatusInternalServerError)
+		fmt.Println("Error fetching workflow jobs", workflowID, err)
+		return
+	}
+	defer rows.Close()
+
+	nodes := []WorkflowNodeStatus{}
+	counts := make(map[string]int)
+	for rows.Next() {
+		var node WorkflowNodeStatus
+		err := rows.Scan(
+			&node.Key, &node.JobID, &node.Name, &node.Status, &node.Retries, &node.CreatedAt, &node.CompletedAt,
+			pq.Array(&node.DependsOn),
+		)
+		if err != nil {
+			http.Error(w, "Failed to fetch workflow", http.StatusInternalServerError)
+			fmt.Println("Error scanning workflow job row:", err)
+			return
+		}
+		nodes = append(nodes, node)
+		counts[node.Status]++
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":         workflowID,
+		"name":       name,
+		"created_at": createdAt,
+		"status":     workflowStatus(counts, len(nodes)),
+		"jobs":       nodes,
+	})
+}
+
+func workflowStatus(counts map[string]int, total int) string {
+	// Summarize the workflow from the statuses of its jobs
+	if counts["completed"] == total {
+		return "completed"
+	}
+
+	active := 0
+	for _, status := range []string{"blocked", "scheduled", "pending", "leased"} {
+		active += counts[status]
+	}
+
+	if active > 0 {
+		return "running"
+	}
+	if counts["cancelled"] > 0 && counts["failed"] == 0 {
+		return "cancelled"
+	}
+	return "failed"
+}
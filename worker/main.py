@@ -17,6 +17,9 @@ REDIS_ADDR = os.getenv("REDIS_ADDR")
 redis_client = redis.Redis.from_url(REDIS_ADDR)
 executor = ThreadPoolExecutor(max_workers=2)
 
+# Running job tasks keyed by job_id, used for cancellation
+running_jobs = {}
+
 @asynccontextmanager
 async def lifespan(app: FastAPI):
     print(f"Worker starting up. WORKER_URL: {WORKER_URL}, COORDINATOR_URL: {COORDINATOR_URL}")
@@ -68,6 +71,8 @@ async def run_job(request: Request):
     data = await request.json()
     job_id = data.get('job_id')
     job_name = data.get('name', 'default')
+    lease_timeout = data.get('lease_timeout')
+    lease_token = data.get('lease_token')
     print(f"Received job_id : {job_id}, job_name: {job_name}")
 
     # Payload validator
@@ -81,7 +86,8 @@ async def run_job(request: Request):
                 "job_id": job_id,
                 "status": "failed",
                 "error": "Invalid job content",
-                "worker_url": WORKER_URL
+                "worker_url": WORKER_URL,
+                "lease_token": lease_token
             }
 
             redis_client.lpush("job_results", json.dumps(result))
@@ -93,17 +99,15 @@ async def run_job(request: Request):
         start_time = time.time()
 
 
-        if job_name == "cpu_intensive":
-            result_data = await simulate_cpu_work()
-        elif job_name == "io_intensive":
-            result_data = await simulate_io_work()
-        elif job_name == "mixed_workload":
-            result_data  = await simulate_mixed_work()
-        elif job_name == "network_task":
-            result_data = await simulate_network_work()
-        else:
-            # Default case
-            result_data = await simulate_variable_work()
+        # Run the workload as a task so /cancel_job can stop it, renewing the lease while it runs
+        task = asyncio.create_task(run_workload(job_name))
+        running_jobs[job_id] = task
+        renew_task = asyncio.create_task(renew_lease(job_id, lease_token, lease_timeout))
+        try:
+            result_data = await task
+        finally:
+            renew_task.cancel()
+            running_jobs.pop(job_id, None)
 
         processing_time = time.time() - start_time
 
@@ -115,25 +119,94 @@ async def run_job(request: Request):
             "status": "completed",
             "result": f"Job {job_id} processed successfully | result_data: {result_data}",
             "processing_time": processing_time,
-            "worker_url": WORKER_URL
+            "worker_url": WORKER_URL,
+            "lease_token": lease_token
         }
 
         redis_client.lpush("job_results", json.dumps(result))
         return {"status": "completed", "message": f"Job {job_id} completed"}
 
+    except asyncio.CancelledError:
+        # Job was cancelled by the coordinator, report it so the worker is released
+        print(f"Job {job_id} cancelled")
+        result = {
+            "job_id": job_id,
+            "status": "cancelled",
+            "worker_url": WORKER_URL,
+            "lease_token": lease_token
+        }
+        redis_client.lpush("job_results", json.dumps(result))
+
+        return {"status": "cancelled", "message": f"Job {job_id} cancelled"}
+
     except Exception as e:
         # Push failed result on exception
         result = {
             "job_id": job_id,
             "status": "failed",
             "error": str(e),
-            "worker_url": WORKER_URL
+            "worker_url": WORKER_URL,
+            "lease_token": lease_token
         }
         redis_client.lpush("job_results", json.dumps(result))
 
         return {"status": "failed", "message": str(e)}
 
 
+@app.post('/cancel_job')
+async def cancel_job(request: Request):
+    data = await request.json()
+    job_id = data.get('job_id')
+
+    task = running_jobs.get(job_id)
+    if task is None:
+        return {"status": "not_found", "message": f"Job {job_id} is not running"}
+
+    task.cancel()
+    print(f"Cancellation requested for job_id: {job_id}")
+    return {"status": "cancelling", "message": f"Job {job_id} cancellation requested"}
+
+
+async def renew_lease(job_id, lease_token, lease_timeout):
+    """
+    Renew the job's lease with the coordinator every third of its timeout, so a long job
+    keeps its lease while a crashed worker still loses it quickly
+    """
+    if not lease_timeout:
+        return
+
+    interval = max(lease_timeout / 3, 1)
+    async with httpx.AsyncClient(timeout=interval) as client:
+        while True:
+            await asyncio.sleep(interval)
+            try:
+                resp = await client.post(f"{COORDINATOR_URL}/leases/{job_id}/renew", json={"worker_url": WORKER_URL, "lease_token": lease_token})
+                if resp.status_code == 409:
+                    # Cancelled, expired or handed to another worker, nothing left to renew
+                    print(f"Lease for job {job_id} is no longer held, stopping renewals")
+                    return
+                print(f"Renewed lease for job {job_id}, status: {resp.status_code}")
+            except Exception as e:
+                print(f"Failed to renew lease for job {job_id}: {e}")
+
+
+async def run_workload(job_name):
+    """
+    Dispatch the job to the matching simulation method
+    """
+    if job_name == "cpu_intensive":
+        return await simulate_cpu_work()
+    elif job_name == "io_intensive":
+        return await simulate_io_work()
+    elif job_name == "mixed_workload":
+        return await simulate_mixed_work()
+    elif job_name == "network_task":
+        return await simulate_network_work()
+    else:
+        # Default case
+        return await simulate_variable_work()
+
+
 # Job Processing simulation methods
 async def simulate_cpu_work():
     """